@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TargetAllowlistConfig limits the targets which can be probed through
+// /probe, so the exporter cannot be used to reach arbitrary hosts. A
+// target is allowed if it matches any entry. Targets in the
+// configuration file are always allowed.
+type TargetAllowlistConfig struct {
+	// CIDRs allow targets given as an IP address in one of the ranges.
+	CIDRs []string `yaml:"cidrs"`
+
+	// Hostnames allow targets with one of the hostnames, ignoring case.
+	Hostnames []string `yaml:"hostnames"`
+
+	// Regexes allow targets with a host matching one of the regular
+	// expressions, which must match the whole host.
+	Regexes []string `yaml:"regexes"`
+
+	prefixes []netip.Prefix
+	regexes  []*regexp.Regexp
+}
+
+func (a *TargetAllowlistConfig) validate() error {
+	a.prefixes = nil
+	for _, cidr := range a.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("cidrs: %w", err)
+		}
+		a.prefixes = append(a.prefixes, prefix.Masked())
+	}
+
+	a.regexes = nil
+	for _, expr := range a.Regexes {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("regexes: %w", err)
+		}
+		a.regexes = append(a.regexes, re)
+	}
+
+	return nil
+}
+
+func (a *TargetAllowlistConfig) allows(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		for _, prefix := range a.prefixes {
+			if prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+	}
+
+	for _, hostname := range a.Hostnames {
+		if strings.EqualFold(hostname, host) {
+			return true
+		}
+	}
+
+	for _, re := range a.regexes {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errTargetNotAllowed is returned for targets which are valid but not
+// in the allowlist.
+var errTargetNotAllowed = errors.New("target is not allowed")
+
+// validHostname matches hostnames and IPv4 addresses.
+var validHostname = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9_-]*[a-zA-Z0-9])?)*\.?$`)
+
+// targetHost validates that target is a host with an optional port,
+// without a scheme, path or anything else, and returns the host. IPv6
+// addresses must be in brackets.
+func targetHost(target string) (string, error) {
+	host, port := target, ""
+	if strings.HasPrefix(target, "[") || strings.Count(target, ":") == 1 {
+		var err error
+		host, port, err = net.SplitHostPort(target)
+		if err != nil {
+			return "", fmt.Errorf("invalid target %q: %w", target, err)
+		}
+
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid target %q: invalid port %q", target, port)
+		}
+	}
+
+	if strings.HasPrefix(target, "[") {
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !addr.Is6() || addr.Zone() != "" {
+			return "", fmt.Errorf("invalid target %q: invalid IPv6 address", target)
+		}
+
+		return host, nil
+	}
+
+	if !validHostname.MatchString(host) {
+		return "", fmt.Errorf("invalid target %q: must be a host with an optional port, e.g. 10.0.0.3 or plug.local:80", target)
+	}
+
+	return host, nil
+}
+
+// checkTarget returns an error if target is invalid or not allowed.
+func (c *Config) checkTarget(target string) error {
+	host, err := targetHost(target)
+	if err != nil {
+		return err
+	}
+
+	if c.AllowedTargets == nil {
+		return nil
+	}
+
+	for _, t := range c.Targets {
+		if t.Address == target {
+			return nil
+		}
+	}
+
+	if !c.AllowedTargets.allows(host) {
+		return fmt.Errorf("%w: %s", errTargetNotAllowed, target)
+	}
+
+	return nil
+}
+
+// checkTargets writes an error and returns false if any of targets is
+// invalid or not allowed.
+func checkTargets(w http.ResponseWriter, targets []string) bool {
+	for _, target := range targets {
+		err := config.checkTarget(target)
+		switch {
+		case errors.Is(err, errTargetNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return false
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+
+	return true
+}
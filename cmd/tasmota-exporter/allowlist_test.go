@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTargetHost(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "10.0.0.3", want: "10.0.0.3"},
+		{target: "10.0.0.3:8080", want: "10.0.0.3"},
+		{target: "livingroom-socket.local", want: "livingroom-socket.local"},
+		{target: "livingroom-socket.local:80", want: "livingroom-socket.local"},
+		{target: "[fd00::3]:80", want: "fd00::3"},
+		{target: "[fd00::3]", wantErr: true},
+		{target: "fd00::3", wantErr: true},
+		{target: "", wantErr: true},
+		{target: "internal-host/admin#", wantErr: true},
+		{target: "internal-host?x=1", wantErr: true},
+		{target: "user@internal-host", wantErr: true},
+		{target: "http://internal-host", wantErr: true},
+		{target: "internal-host:0", wantErr: true},
+		{target: "internal-host:http", wantErr: true},
+		{target: "internal host", wantErr: true},
+		{target: "[fd00::3%eth0]:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := targetHost(tt.target)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	cfg := &Config{
+		Targets: []TargetConfig{{Address: "configured.example.com"}},
+		AllowedTargets: &TargetAllowlistConfig{
+			CIDRs:     []string{"10.0.0.0/24", "fd00::/64"},
+			Hostnames: []string{"Balcony.local"},
+			Regexes:   []string{`[a-z]+-socket\.local`},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validating config: %s", err)
+	}
+
+	allowed := []string{
+		"10.0.0.3",
+		"10.0.0.3:8080",
+		"[fd00::3]:80",
+		"balcony.local",
+		"livingroom-socket.local",
+		"configured.example.com",
+	}
+	for _, target := range allowed {
+		if err := cfg.checkTarget(target); err != nil {
+			t.Errorf("expected %s to be allowed, got %s", target, err)
+		}
+	}
+
+	denied := []string{
+		"10.0.1.3",
+		"169.254.169.254",
+		"[fd01::3]:80",
+		"other.local",
+		"livingroom-socket.local.attacker.com",
+	}
+	for _, target := range denied {
+		if err := cfg.checkTarget(target); !errors.Is(err, errTargetNotAllowed) {
+			t.Errorf("expected %s not to be allowed, got %v", target, err)
+		}
+	}
+
+	if err := (&Config{AllowedTargets: &TargetAllowlistConfig{CIDRs: []string{"10.0.0.0"}}}).validate(); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if err := (&Config{AllowedTargets: &TargetAllowlistConfig{Regexes: []string{"("}}}).validate(); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+	if err := (&Config{Targets: []TargetConfig{{Address: "plug/admin"}}}).validate(); err == nil {
+		t.Error("expected an error for an invalid configured target")
+	}
+}
+
+func TestProbeDisallowedTarget(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = &Config{AllowedTargets: &TargetAllowlistConfig{CIDRs: []string{"10.0.0.0/24"}}}
+	if err := config.validate(); err != nil {
+		t.Fatalf("validating config: %s", err)
+	}
+
+	tests := []struct {
+		targets []string
+		want    int
+	}{
+		{targets: []string{"internal-host/admin#"}, want: http.StatusBadRequest},
+		{targets: []string{"192.168.1.1"}, want: http.StatusForbidden},
+		{targets: []string{"10.0.0.3", "192.168.1.1"}, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.targets, ","), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/probe?"+url.Values{"target": tt.targets}.Encode(), nil)
+			rec := httptest.NewRecorder()
+			tasmotaHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ApplianceConfig detects the cycles of an appliance plugged into a
+// target, like a washing machine or dishwasher, from its power.
+type ApplianceConfig struct {
+	// StartPower is the power in W above which the appliance is
+	// running.
+	StartPower float64 `yaml:"start_power"`
+
+	// StopPower is the power in W below which a running appliance may
+	// be finished, it must not be above StartPower. The gap between
+	// the two avoids flapping around a single threshold. Defaults to
+	// StartPower.
+	StopPower float64 `yaml:"stop_power"`
+
+	// FinishAfter is how long the power must stay below StopPower for
+	// the cycle to be finished, longer than the pauses of the
+	// appliance during a cycle, e.g. while soaking. Defaults to
+	// defaultApplianceFinishAfter.
+	FinishAfter time.Duration `yaml:"finish_after"`
+
+	// MinDuration discards cycles shorter than it, like a pump
+	// running for a few seconds.
+	MinDuration time.Duration `yaml:"min_duration"`
+
+	// FinishedFor is how long the appliance is reported as finished
+	// before it is idle again. Defaults to defaultApplianceFinishedFor.
+	FinishedFor time.Duration `yaml:"finished_for"`
+}
+
+const (
+	defaultApplianceFinishAfter = 5 * time.Minute
+	defaultApplianceFinishedFor = time.Hour
+)
+
+func (a *ApplianceConfig) validate() error {
+	if a.StartPower <= 0 {
+		return errors.New("start_power must be positive")
+	}
+	if a.StopPower < 0 || a.StopPower > a.StartPower {
+		return errors.New("stop_power must be between 0 and start_power")
+	}
+	if a.FinishAfter < 0 || a.MinDuration < 0 || a.FinishedFor < 0 {
+		return errors.New("finish_after, min_duration and finished_for must be positive")
+	}
+
+	return nil
+}
+
+func (a *ApplianceConfig) stopPower() float64 {
+	if a.StopPower == 0 {
+		return a.StartPower
+	}
+
+	return a.StopPower
+}
+
+func (a *ApplianceConfig) finishAfter() time.Duration {
+	if a.FinishAfter == 0 {
+		return defaultApplianceFinishAfter
+	}
+
+	return a.FinishAfter
+}
+
+func (a *ApplianceConfig) finishedFor() time.Duration {
+	if a.FinishedFor == 0 {
+		return defaultApplianceFinishedFor
+	}
+
+	return a.FinishedFor
+}
+
+const (
+	applianceIdle     = "idle"
+	applianceRunning  = "running"
+	applianceFinished = "finished"
+)
+
+var applianceStates = []string{applianceIdle, applianceRunning, applianceFinished}
+
+// applianceState is the detected state of an appliance, persisted with
+// the state of its target so cycles survive restarts.
+type applianceState struct {
+	// State is idle, running or finished.
+	State string `json:"state"`
+
+	// Since is when the appliance entered its state.
+	Since time.Time `json:"since"`
+
+	// CycleStart and CycleStartTotal are when the current cycle
+	// started and the Total counter at that time.
+	CycleStart      time.Time `json:"cycle_start"`
+	CycleStartTotal float64   `json:"cycle_start_total"`
+
+	// LowSince is when the power of the running appliance dropped
+	// below the stop power, zero while it is above.
+	LowSince time.Time `json:"low_since"`
+
+	// Cycles is the number of cycles finished.
+	Cycles int `json:"cycles"`
+
+	// LastCycleEnergy and LastCycleDuration describe the last cycle
+	// finished.
+	LastCycleEnergy   float64       `json:"last_cycle_energy"`
+	LastCycleDuration time.Duration `json:"last_cycle_duration"`
+}
+
+// next returns the state of the appliance after a probe at now.
+func (a *ApplianceConfig) next(s applianceState, tp TasmotaPlug, now time.Time) applianceState {
+	if s.State == "" {
+		s = applianceState{State: applianceIdle, Since: now}
+	}
+
+	switch s.State {
+	case applianceIdle, applianceFinished:
+		if tp.Power >= a.StartPower {
+			s.State = applianceRunning
+			s.Since = now
+			s.CycleStart = now
+			s.CycleStartTotal = tp.Total
+			s.LowSince = time.Time{}
+		} else if s.State == applianceFinished && now.Sub(s.Since) >= a.finishedFor() {
+			s.State = applianceIdle
+			s.Since = now
+		}
+
+	case applianceRunning:
+		if tp.Power >= a.stopPower() {
+			s.LowSince = time.Time{}
+			break
+		}
+
+		if s.LowSince.IsZero() {
+			s.LowSince = now
+		}
+		if now.Sub(s.LowSince) < a.finishAfter() {
+			break
+		}
+
+		// The cycle ended when the power dropped, not when it has
+		// been low for long enough.
+		duration := s.LowSince.Sub(s.CycleStart)
+		s.LowSince = time.Time{}
+		s.Since = now
+		if duration < a.MinDuration {
+			s.State = applianceIdle
+			break
+		}
+
+		s.State = applianceFinished
+		s.Cycles++
+		s.LastCycleEnergy = max(tp.Total-s.CycleStartTotal, 0)
+		s.LastCycleDuration = duration
+	}
+
+	return s
+}
+
+// recordAppliance updates the appliance state of target with a
+// successful probe.
+func recordAppliance(cfg *ApplianceConfig, target string, tp TasmotaPlug, now time.Time) {
+	state.update(target, func(ts *targetState, known bool) {
+		var s applianceState
+		if ts.Appliance != nil {
+			s = *ts.Appliance
+		}
+
+		// The state is replaced rather than modified, as copies of the
+		// target state handed out share it.
+		next := cfg.next(s, tp, now)
+		ts.Appliance = &next
+	})
+}
+
+// registerApplianceMetrics registers the metrics of the appliance of a
+// target.
+func registerApplianceMetrics(reg prometheus.Registerer, s *applianceState, labels prometheus.Labels) {
+	stateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "tasmota_appliance_state",
+		Help:        "state of the appliance plugged into tasmota plug, 1 for the current state",
+		ConstLabels: labels,
+	}, []string{"state"})
+	for _, name := range applianceStates {
+		value := 0.0
+		if name == s.State {
+			value = 1
+		}
+		stateGauge.WithLabelValues(name).Set(value)
+	}
+	reg.MustRegister(stateGauge)
+
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "tasmota_appliance_cycles_total",
+		Help:        "number of cycles of the appliance plugged into tasmota plug",
+		ConstLabels: labels,
+	}, func() float64 { return float64(s.Cycles) }))
+
+	if s.Cycles == 0 {
+		return
+	}
+
+	energy := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "tasmota_appliance_cycle_energy_kwh",
+		Help:        "energy used by the last cycle of the appliance plugged into tasmota plug in kilowatts hours (kWh)",
+		ConstLabels: labels,
+	})
+	energy.Set(s.LastCycleEnergy)
+	reg.MustRegister(energy)
+
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "tasmota_appliance_cycle_duration_seconds",
+		Help:        "duration of the last cycle of the appliance plugged into tasmota plug in seconds",
+		ConstLabels: labels,
+	})
+	duration.Set(s.LastCycleDuration.Seconds())
+	reg.MustRegister(duration)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestApplianceNext(t *testing.T) {
+	cfg := &ApplianceConfig{
+		StartPower:  50,
+		StopPower:   5,
+		FinishAfter: 5 * time.Minute,
+		MinDuration: 10 * time.Minute,
+		FinishedFor: 30 * time.Minute,
+	}
+
+	now := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	steps := []struct {
+		after      time.Duration
+		power      float64
+		total      float64
+		wantState  string
+		wantCycles int
+	}{
+		{power: 1, total: 10, wantState: applianceIdle},
+		// A pump running briefly is too short to be a cycle.
+		{after: time.Minute, power: 80, total: 10, wantState: applianceRunning},
+		{after: time.Minute, power: 1, total: 10.01, wantState: applianceRunning},
+		{after: 5 * time.Minute, power: 1, total: 10.01, wantState: applianceIdle},
+		// The power staying above the stop power while soaking does not
+		// finish the cycle, neither do short pauses below it.
+		{after: time.Minute, power: 2000, total: 10.01, wantState: applianceRunning},
+		{after: 30 * time.Minute, power: 20, total: 10.6, wantState: applianceRunning},
+		{after: 20 * time.Minute, power: 2, total: 10.8, wantState: applianceRunning},
+		{after: 3 * time.Minute, power: 400, total: 10.81, wantState: applianceRunning},
+		{after: 10 * time.Minute, power: 2, total: 11.01, wantState: applianceRunning},
+		{after: 5 * time.Minute, power: 2, total: 11.01, wantState: applianceFinished, wantCycles: 1},
+		{after: 29 * time.Minute, power: 2, total: 11.02, wantState: applianceFinished, wantCycles: 1},
+		{after: time.Minute, power: 2, total: 11.02, wantState: applianceIdle, wantCycles: 1},
+	}
+
+	var s applianceState
+	for i, step := range steps {
+		now = now.Add(step.after)
+		s = cfg.next(s, TasmotaPlug{Power: step.power, Total: step.total}, now)
+
+		if s.State != step.wantState {
+			t.Errorf("step %d: state = %s, want %s", i, s.State, step.wantState)
+		}
+		if s.Cycles != step.wantCycles {
+			t.Errorf("step %d: cycles = %d, want %d", i, s.Cycles, step.wantCycles)
+		}
+	}
+
+	if s.LastCycleEnergy < 0.999 || s.LastCycleEnergy > 1.001 {
+		t.Errorf("last cycle energy = %v, want 1", s.LastCycleEnergy)
+	}
+	// The cycle ended when the power dropped for the last time.
+	if want := 63 * time.Minute; s.LastCycleDuration != want {
+		t.Errorf("last cycle duration = %s, want %s", s.LastCycleDuration, want)
+	}
+}
+
+func TestApplianceMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	registerApplianceMetrics(reg, &applianceState{
+		State:             applianceFinished,
+		Cycles:            3,
+		LastCycleEnergy:   0.8,
+		LastCycleDuration: 90 * time.Minute,
+	}, prometheus.Labels{"target": "washer.local"})
+
+	expected := `
+# HELP tasmota_appliance_cycle_duration_seconds duration of the last cycle of the appliance plugged into tasmota plug in seconds
+# TYPE tasmota_appliance_cycle_duration_seconds gauge
+tasmota_appliance_cycle_duration_seconds{target="washer.local"} 5400
+# HELP tasmota_appliance_cycle_energy_kwh energy used by the last cycle of the appliance plugged into tasmota plug in kilowatts hours (kWh)
+# TYPE tasmota_appliance_cycle_energy_kwh gauge
+tasmota_appliance_cycle_energy_kwh{target="washer.local"} 0.8
+# HELP tasmota_appliance_cycles_total number of cycles of the appliance plugged into tasmota plug
+# TYPE tasmota_appliance_cycles_total counter
+tasmota_appliance_cycles_total{target="washer.local"} 3
+# HELP tasmota_appliance_state state of the appliance plugged into tasmota plug, 1 for the current state
+# TYPE tasmota_appliance_state gauge
+tasmota_appliance_state{state="finished",target="washer.local"} 1
+tasmota_appliance_state{state="idle",target="washer.local"} 0
+tasmota_appliance_state{state="running",target="washer.local"} 0
+`
+	if err := promtest.GatherAndCompare(reg, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApplianceValidate(t *testing.T) {
+	for name, cfg := range map[string]ApplianceConfig{
+		"missing start power":       {},
+		"stop above start power":    {StartPower: 10, StopPower: 20},
+		"negative finish after":     {StartPower: 10, FinishAfter: -time.Minute},
+		"negative finished for":     {StartPower: 10, FinishedFor: -time.Minute},
+		"negative minimum duration": {StartPower: 10, MinDuration: -time.Minute},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cfg := ApplianceConfig{StartPower: 10}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CircuitBreakerConfig stops the exporter from querying a plug which
+// keeps failing, e.g. because it is unplugged, until it has had time to
+// come back.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is how many probes in a row must fail before
+	// the circuit opens, 0 disables the circuit breaker.
+	FailureThreshold int `yaml:"failure_threshold"`
+
+	// OpenDuration is how long the circuit stays open before a single
+	// probe is let through to check if the plug is back. Defaults to
+	// defaultCircuitOpenDuration.
+	OpenDuration time.Duration `yaml:"open_duration"`
+}
+
+const defaultCircuitOpenDuration = time.Minute
+
+func (c CircuitBreakerConfig) validate() error {
+	if c.FailureThreshold < 0 {
+		return errors.New("failure_threshold must be positive")
+	}
+	if c.OpenDuration < 0 {
+		return errors.New("open_duration must be positive")
+	}
+
+	return nil
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+var circuitStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tasmota_exporter_target_circuit_state",
+	Help: "state of the circuit breaker of a tasmota plug, 0 is closed, 1 is open and 2 is half-open",
+}, []string{"target"})
+
+func init() {
+	exporterRegistry.MustRegister(circuitStateGauge)
+}
+
+// errCircuitOpen is returned for probes of a target which has failed too
+// many times in a row.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreaker tracks the failures of a single target.
+type circuitBreaker struct {
+	target string
+	cfg    CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// allow returns an error if the target should not be queried.
+func (b *circuitBreaker) allow() error {
+	if b.cfg.FailureThreshold == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		openDuration := b.cfg.OpenDuration
+		if openDuration == 0 {
+			openDuration = defaultCircuitOpenDuration
+		}
+
+		if getNow().Sub(b.openedAt) < openDuration {
+			return errCircuitOpen
+		}
+
+		b.setState(circuitHalfOpen)
+		b.trial = true
+
+		return nil
+	case circuitHalfOpen:
+		// Only one probe is let through to check if the plug is back.
+		if b.trial {
+			return fmt.Errorf("%w, waiting for trial probe", errCircuitOpen)
+		}
+		b.trial = true
+	}
+
+	return nil
+}
+
+// record updates the breaker with the outcome of a probe.
+func (b *circuitBreaker) record(err error) {
+	if b.cfg.FailureThreshold == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+
+	if err == nil {
+		b.failures = 0
+		b.setState(circuitClosed)
+
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		if b.state != circuitOpen {
+			slog.Warn("opening circuit breaker", "target", b.target, "failures", b.failures)
+		}
+		b.openedAt = getNow()
+		b.setState(circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(s circuitState) {
+	b.state = s
+	circuitStateGauge.WithLabelValues(b.target).Set(float64(s))
+}
+
+// circuitBreakers holds the circuit breaker of every target probed.
+type circuitBreakers struct {
+	cfg CircuitBreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+var breakers = newCircuitBreakers(CircuitBreakerConfig{})
+
+func newCircuitBreakers(cfg CircuitBreakerConfig) *circuitBreakers {
+	return &circuitBreakers{
+		cfg:      cfg,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+func (c *circuitBreakers) get(target string) *circuitBreaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if b, ok := c.breakers[target]; ok {
+		return b
+	}
+
+	b := &circuitBreaker{target: target, cfg: c.cfg}
+	c.breakers[target] = b
+	if c.cfg.FailureThreshold > 0 {
+		b.setState(circuitClosed)
+	}
+
+	return b
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	originalNowFunc := getNow
+	defer func() { getNow = originalNowFunc }()
+
+	now := time.Date(2024, 7, 26, 10, 0, 0, 0, time.UTC)
+	getNow = func() time.Time { return now }
+
+	target := "circuit-test"
+	b := newCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 3, OpenDuration: time.Minute}).get(target)
+	failure := errors.New("no route to host")
+
+	expectState := func(want circuitState) {
+		t.Helper()
+		if got := promtest.ToFloat64(circuitStateGauge.WithLabelValues(target)); got != float64(want) {
+			t.Errorf("circuit state = %v, want %v", got, want)
+		}
+	}
+
+	for range 2 {
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() error = %s", err)
+		}
+		b.record(failure)
+	}
+	expectState(circuitClosed)
+
+	// A success resets the failure count.
+	b.record(nil)
+	for range 3 {
+		b.record(failure)
+	}
+	expectState(circuitOpen)
+
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("allow() = %v, want %v", err, errCircuitOpen)
+	}
+
+	// After the open duration, a single trial probe is let through.
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after open duration error = %s", err)
+	}
+	expectState(circuitHalfOpen)
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("allow() during trial = %v, want %v", err, errCircuitOpen)
+	}
+
+	// A failed trial opens the circuit again.
+	b.record(failure)
+	expectState(circuitOpen)
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after open duration error = %s", err)
+	}
+	b.record(nil)
+	expectState(circuitClosed)
+
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after successful trial error = %s", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreakers(CircuitBreakerConfig{}).get("circuit-disabled")
+	for range 10 {
+		b.record(errors.New("failure"))
+	}
+
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() with disabled breaker error = %s", err)
+	}
+}
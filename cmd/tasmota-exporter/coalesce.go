@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var coalescedProbesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tasmota_exporter_probes_coalesced_total",
+	Help: "number of probes served by sharing the result of another probe of the same target",
+}, []string{"target"})
+
+func init() {
+	exporterRegistry.MustRegister(coalescedProbesCounter)
+}
+
+// probeCall is a probe of a target which is in flight or has finished
+// recently.
+type probeCall struct {
+	done     chan struct{}
+	res      probeResult
+	finished time.Time
+}
+
+// probeCoalescer deduplicates probes of the same target. Tasmota plugs
+// handle one connection at a time, so when several probes of a target
+// come in at once, e.g. from Prometheus HA replicas, only one request is
+// made to the plug and the result is shared.
+type probeCoalescer struct {
+	mu    sync.Mutex
+	calls map[string]*probeCall
+}
+
+var coalescer = &probeCoalescer{calls: make(map[string]*probeCall)}
+
+// do runs probe for key, unless a probe for key is already in flight or
+// finished less than reuse ago, in which case its result is returned.
+// The probe is not cancelled if the caller which started it goes away,
+// as others might be waiting for it.
+func (c *probeCoalescer) do(ctx context.Context, key string, reuse time.Duration, probe func(context.Context) probeResult) (probeResult, bool) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok && (call.finished.IsZero() || time.Since(call.finished) <= reuse) {
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.res, true
+		case <-ctx.Done():
+			return probeResult{err: ctx.Err()}, true
+		}
+	}
+
+	call := &probeCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.res = probe(context.WithoutCancel(ctx))
+
+	c.mu.Lock()
+	call.finished = time.Now()
+	c.mu.Unlock()
+	close(call.done)
+
+	time.AfterFunc(reuse, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.calls[key] == call {
+			delete(c.calls, key)
+		}
+	})
+
+	return call.res, false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProbeCoalescing(t *testing.T) {
+	originalState := state
+	originalConfig := config
+	defer func() {
+		state = originalState
+		config = originalConfig
+	}()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+	config = &Config{ProbeReuseWindow: time.Hour}
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		fmt.Fprint(w, fakePlugPage)
+	}))
+	defer srv.Close()
+
+	target := TargetConfig{Address: strings.TrimPrefix(srv.URL, "http://")}
+	before := promtest.ToFloat64(coalescedProbesCounter.WithLabelValues(target.Address))
+
+	var wg sync.WaitGroup
+	results := make([]probeResult, 5)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = probeTarget(context.Background(), target)
+		}()
+	}
+
+	// Give all the probes time to join the one in flight.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, res := range results {
+		if res.err != nil || res.target != target.Address || res.plug.Power != 7 {
+			t.Errorf("result %d: unexpected result %+v", i, res)
+		}
+	}
+
+	// Within the reuse window, the result is reused.
+	if res := probeTarget(context.Background(), target); res.err != nil {
+		t.Errorf("unexpected error from reused result: %s", res.err)
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("requests to plug = %d, want 1", got)
+	}
+
+	after := promtest.ToFloat64(coalescedProbesCounter.WithLabelValues(target.Address))
+	if got := after - before; got != 5 {
+		t.Errorf("coalesced probes = %v, want 5", got)
+	}
+}
+
+func TestProbeCoalescingWithoutReuse(t *testing.T) {
+	c := &probeCoalescer{calls: make(map[string]*probeCall)}
+
+	probes := 0
+	probe := func(context.Context) probeResult {
+		probes++
+		return probeResult{}
+	}
+
+	c.do(context.Background(), "plug", 0, probe)
+	time.Sleep(10 * time.Millisecond)
+	if _, coalesced := c.do(context.Background(), "plug", 0, probe); coalesced {
+		t.Errorf("expected finished probe to not be reused without a reuse window")
+	}
+
+	if probes != 2 {
+		t.Errorf("probes = %d, want 2", probes)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"tailscale.com/envknob"
+)
+
+var overrideConfigFile = envknob.String("TASMOTA_EXPORTER_CONFIG_FILE")
+
+// Config is the optional configuration file of the exporter. The exporter
+// works without one, it is only needed for features that keep state or
+// need to know more than the target address.
+type Config struct {
+	// Timezone is the IANA name of the timezone used for anything
+	// related to calendar time, like tariffs. Defaults to the local
+	// timezone of the exporter.
+	Timezone string `yaml:"timezone"`
+
+	// StateFile is where the exporter persists state across restarts.
+	// If empty, state is only kept in memory.
+	StateFile string `yaml:"state_file"`
+
+	// Tariff is used to compute the energy cost per target.
+	Tariff *TariffConfig `yaml:"tariff"`
+
+	// Modules are named sets of settings for probing plugs, in
+	// addition to the builtin web and status modules.
+	Modules map[string]ModuleConfig `yaml:"modules"`
+
+	// Targets are the plugs probed by /metrics/all.
+	Targets []TargetConfig `yaml:"targets"`
+
+	// AllowedTargets limits the targets which can be probed, if set.
+	AllowedTargets *TargetAllowlistConfig `yaml:"allowed_targets"`
+
+	// Poll enables polling the targets in the background.
+	Poll *PollConfig `yaml:"poll"`
+
+	// MaxConcurrentProbes limits how many targets /metrics/all probes
+	// at once. Defaults to defaultMaxConcurrentProbes.
+	MaxConcurrentProbes int `yaml:"max_concurrent_probes"`
+
+	// ProbeReuseWindow is how long the result of a probe is shared
+	// with other probes of the same target after it has finished.
+	// Probes in flight are always shared.
+	ProbeReuseWindow time.Duration `yaml:"probe_reuse_window"`
+
+	// DeviceLimits limits the requests made to each plug.
+	DeviceLimits DeviceLimitsConfig `yaml:"device_limits"`
+
+	// Retry controls retries of requests to plugs.
+	Retry RetryConfig `yaml:"retry"`
+
+	// CircuitBreaker stops querying plugs which keep failing.
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// HTTPClient tunes the HTTP client used to query plugs.
+	HTTPClient HTTPClientConfig `yaml:"http_client"`
+
+	// Rules switch plugs when their values exceed a threshold. They
+	// need polling to be enabled.
+	Rules []RuleConfig `yaml:"rules"`
+
+	// Control enables the API to switch plugs on and off.
+	Control *ControlConfig `yaml:"control"`
+
+	// Tailscale serves the exporter on a tailnet.
+	Tailscale *TailscaleConfig `yaml:"tailscale"`
+
+	// Events sends notifications when something changes on a plug.
+	Events *EventsConfig `yaml:"events"`
+
+	// Influx controls the InfluxDB line protocol output.
+	Influx *InfluxConfig `yaml:"influx"`
+
+	location *time.Location
+}
+
+const defaultMaxConcurrentProbes = 8
+
+// TargetConfig is a plug known to the exporter.
+type TargetConfig struct {
+	// Address is the host, and optionally port, of the plug.
+	Address string `yaml:"address"`
+
+	// Module is the name of the module used to probe the target.
+	// Defaults to defaultModule.
+	Module string `yaml:"module"`
+
+	// Interval overrides how often the target is polled in the
+	// background, if polling is enabled.
+	Interval time.Duration `yaml:"interval"`
+
+	// Appliance detects the cycles of the appliance plugged into the
+	// target, if set.
+	Appliance *ApplianceConfig `yaml:"appliance"`
+}
+
+func (t TargetConfig) module() string {
+	if t.Module == "" {
+		return defaultModule
+	}
+
+	return t.Module
+}
+
+// target returns the target with address in the configuration file.
+func (c *Config) target(address string) (TargetConfig, bool) {
+	for _, t := range c.Targets {
+		if t.Address == address {
+			return t, true
+		}
+	}
+
+	return TargetConfig{}, false
+}
+
+// loadConfig reads and validates the configuration file at path.
+// An empty path returns the default configuration.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("validating config file: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	c.location = time.Local
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return fmt.Errorf("loading timezone: %w", err)
+		}
+		c.location = loc
+	}
+
+	if c.Tariff != nil {
+		if err := c.Tariff.validate(); err != nil {
+			return fmt.Errorf("tariff: %w", err)
+		}
+	}
+
+	for name, m := range c.Modules {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("module %s: %w", name, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, target := range c.Targets {
+		if target.Address == "" {
+			return fmt.Errorf("target %d: address must be set", i)
+		}
+		if seen[target.Address] {
+			return fmt.Errorf("target %d: duplicate address %q", i, target.Address)
+		}
+		seen[target.Address] = true
+
+		if _, err := targetHost(target.Address); err != nil {
+			return fmt.Errorf("target %d: %w", i, err)
+		}
+
+		if _, err := c.module(target.Module); err != nil {
+			return fmt.Errorf("target %s: %w", target.Address, err)
+		}
+
+		if target.Interval < 0 {
+			return fmt.Errorf("target %s: interval must be positive", target.Address)
+		}
+
+		if target.Appliance != nil {
+			if err := target.Appliance.validate(); err != nil {
+				return fmt.Errorf("target %s: appliance: %w", target.Address, err)
+			}
+		}
+	}
+
+	if c.AllowedTargets != nil {
+		if err := c.AllowedTargets.validate(); err != nil {
+			return fmt.Errorf("allowed_targets: %w", err)
+		}
+	}
+
+	if c.Poll != nil {
+		if err := c.Poll.validate(); err != nil {
+			return fmt.Errorf("poll: %w", err)
+		}
+	}
+
+	if c.MaxConcurrentProbes < 0 {
+		return errors.New("max_concurrent_probes must be positive")
+	}
+
+	if c.ProbeReuseWindow < 0 {
+		return errors.New("probe_reuse_window must be positive")
+	}
+
+	if err := c.DeviceLimits.validate(); err != nil {
+		return fmt.Errorf("device_limits: %w", err)
+	}
+
+	if err := c.Retry.validate(); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+
+	if err := c.CircuitBreaker.validate(); err != nil {
+		return fmt.Errorf("circuit_breaker: %w", err)
+	}
+
+	if err := c.HTTPClient.validate(); err != nil {
+		return fmt.Errorf("http_client: %w", err)
+	}
+
+	if len(c.Rules) > 0 && c.Poll == nil {
+		return errors.New("rules need poll to be set")
+	}
+	ruleNames := make(map[string]bool)
+	for i := range c.Rules {
+		if err := c.Rules[i].validate(c); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		if ruleNames[c.Rules[i].Name] {
+			return fmt.Errorf("rule %d: duplicate name %q", i, c.Rules[i].Name)
+		}
+		ruleNames[c.Rules[i].Name] = true
+	}
+
+	if c.Control != nil {
+		if err := c.Control.validate(); err != nil {
+			return fmt.Errorf("control: %w", err)
+		}
+	}
+
+	if c.Tailscale != nil {
+		if err := c.Tailscale.validate(); err != nil {
+			return fmt.Errorf("tailscale: %w", err)
+		}
+	}
+
+	if c.Events != nil {
+		if err := c.Events.validate(); err != nil {
+			return fmt.Errorf("events: %w", err)
+		}
+	}
+
+	if c.Influx != nil {
+		if err := c.Influx.validate(c); err != nil {
+			return fmt.Errorf("influx: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) maxConcurrentProbes() int {
+	if c.MaxConcurrentProbes == 0 {
+		return defaultMaxConcurrentProbes
+	}
+
+	return c.MaxConcurrentProbes
+}
+
+// Location returns the timezone calendar time should be computed in.
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+
+	return c.location
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ControlConfig enables the API to switch plugs on and off. It is
+// disabled unless at least one token is configured.
+type ControlConfig struct {
+	// Tokens maps the names of clients to the bearer tokens they
+	// authenticate with. The names are recorded in the audit log.
+	Tokens map[string]string `yaml:"tokens"`
+}
+
+func (c *ControlConfig) validate() error {
+	for name, token := range c.Tokens {
+		if len(token) < 16 {
+			return fmt.Errorf("token of %s must be at least 16 characters", name)
+		}
+	}
+
+	return nil
+}
+
+// client returns the name of the client authenticating with the bearer
+// token of r, and false if there is none.
+func (c *ControlConfig) client(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || c == nil {
+		return "", false
+	}
+
+	client, found := "", false
+	for name, t := range c.Tokens {
+		// Compare every token so the time taken does not depend on
+		// which one matches.
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			client, found = name, true
+		}
+	}
+
+	return client, found
+}
+
+// powerAction is what to do with a relay, as understood by the Power
+// command of Tasmota.
+type powerAction string
+
+const (
+	powerOn     powerAction = "on"
+	powerOff    powerAction = "off"
+	powerToggle powerAction = "toggle"
+)
+
+// powerRequest is the body of POST /api/v1/targets/{target}/power.
+type powerRequest struct {
+	// Action is on, off or toggle.
+	Action powerAction `json:"action"`
+
+	// Relay is the index of the relay, starting at 1. Defaults to 1.
+	Relay int `json:"relay"`
+
+	// Module is used to reach the plug. Defaults to the module of the
+	// target in the configuration file, or the default module.
+	Module string `json:"module"`
+}
+
+// powerResponse is the state of the relay after the command.
+type powerResponse struct {
+	Target string `json:"target"`
+	Relay  int    `json:"relay"`
+	On     bool   `json:"on"`
+}
+
+// maxPowerRelay is the highest relay index supported by Tasmota.
+const maxPowerRelay = 32
+
+// powerHandler switches a relay of a plug with the Power command of
+// Tasmota and returns its new state. Every attempt is recorded in the
+// audit log.
+func powerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if config.Control == nil || len(config.Control.Tokens) == 0 {
+		http.Error(w, "Control API is disabled", http.StatusNotFound)
+		return
+	}
+
+	target := r.PathValue("target")
+	audit := slog.With("component", "audit", "remote_addr", r.RemoteAddr, "target", target)
+
+	client, ok := config.Control.client(r)
+	if !ok {
+		audit.Warn("rejected power command", "reason", "invalid token")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tasmota-exporter"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	audit = audit.With("client", client)
+
+	if !checkTargets(w, []string{target}) {
+		audit.Warn("rejected power command", "reason", "target not allowed")
+		return
+	}
+
+	var req powerRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		audit.Warn("rejected power command", "reason", "invalid request body", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.Relay == 0 {
+		req.Relay = 1
+	}
+	if req.Module == "" {
+		req.Module = config.targetModule(target)
+	}
+	audit = audit.With("action", req.Action, "relay", req.Relay)
+
+	if req.Action != powerOn && req.Action != powerOff && req.Action != powerToggle {
+		audit.Warn("rejected power command", "reason", "invalid action")
+		http.Error(w, "Action must be on, off or toggle", http.StatusBadRequest)
+		return
+	}
+	if req.Relay < 1 || req.Relay > maxPowerRelay {
+		audit.Warn("rejected power command", "reason", "invalid relay")
+		http.Error(w, fmt.Sprintf("Relay must be between 1 and %d", maxPowerRelay), http.StatusBadRequest)
+		return
+	}
+	module, err := config.module(req.Module)
+	if err != nil {
+		audit.Warn("rejected power command", "reason", "unknown module", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	on, err := switchPower(r.Context(), target, module, req.Relay, req.Action)
+	if err != nil {
+		audit.Error("power command failed", "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	audit.Info("power command succeeded", "on", on)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(powerResponse{Target: target, Relay: req.Relay, On: on})
+}
+
+// switchPower sends the Power command for relay to the plug at target
+// and returns if the relay is on afterwards.
+func switchPower(ctx context.Context, target string, module ModuleConfig, relay int, action powerAction) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cmd := fmt.Sprintf("Power%d %s", relay, action)
+
+	// The command is sent once, without the retries of probes, as
+	// retrying a toggle which timed out could switch the relay twice.
+	resp, err := fetchOnce(ctx, target, module, "/cm?cmnd="+url.PathEscape(cmd))
+	if err != nil {
+		return false, err
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.body, &result); err != nil {
+		return false, fmt.Errorf("failed to parse response of tasmota target: %w", err)
+	}
+
+	// Plugs with a single relay answer with POWER, others with the
+	// index of the relay.
+	raw, ok := result[fmt.Sprintf("POWER%d", relay)]
+	if !ok && relay == 1 {
+		raw, ok = result["POWER"]
+	}
+	if !ok {
+		return false, fmt.Errorf("tasmota target has no relay %d, got %s", relay, resp.body)
+	}
+
+	var state string
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return false, fmt.Errorf("failed to parse state of relay %d: %w", relay, err)
+	}
+
+	switch state {
+	case "ON":
+		return true, nil
+	case "OFF":
+		return false, nil
+	}
+
+	return false, errors.New("unexpected state of relay: " + state)
+}
+
+// targetModule returns the module of target in the configuration file,
+// or the default module.
+func (c *Config) targetModule(target string) string {
+	if t, ok := c.target(target); ok {
+		return t.module()
+	}
+
+	return defaultModule
+}
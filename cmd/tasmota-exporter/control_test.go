@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newFakeRelayPlug starts a plug with two relays answering the Power
+// command.
+func newFakeRelayPlug(t *testing.T) string {
+	t.Helper()
+
+	var mu sync.Mutex
+	relays := map[string]bool{"1": false, "2": false}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var relay, action string
+		if _, err := fmt.Sscanf(r.URL.Query().Get("cmnd"), "Power%s %s", &relay, &action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch action {
+		case "on":
+			relays[relay] = true
+		case "off":
+			relays[relay] = false
+		case "toggle":
+			relays[relay] = !relays[relay]
+		}
+
+		state := "OFF"
+		if relays[relay] {
+			state = "ON"
+		}
+		fmt.Fprintf(w, `{"POWER%s":%q}`, relay, state)
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestPowerHandler(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	target := newFakeRelayPlug(t)
+	const token = "0123456789abcdef"
+
+	enabled := &Config{
+		Control:        &ControlConfig{Tokens: map[string]string{"load-shedder": token}},
+		AllowedTargets: &TargetAllowlistConfig{CIDRs: []string{"127.0.0.0/8"}},
+	}
+	if err := enabled.validate(); err != nil {
+		t.Fatalf("validating config: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		config *Config
+		method string
+		target string
+		token  string
+		body   string
+		want   int
+		wantOn bool
+	}{
+		{name: "disabled", config: &Config{}, token: token, body: `{"action":"on"}`, want: http.StatusNotFound},
+		{name: "no token", body: `{"action":"on"}`, want: http.StatusUnauthorized},
+		{name: "wrong token", token: "fedcba9876543210", body: `{"action":"on"}`, want: http.StatusUnauthorized},
+		{name: "not allowed", target: "10.0.0.3", token: token, body: `{"action":"on"}`, want: http.StatusForbidden},
+		{name: "invalid action", token: token, body: `{"action":"explode"}`, want: http.StatusBadRequest},
+		{name: "invalid relay", token: token, body: `{"action":"on","relay":33}`, want: http.StatusBadRequest},
+		{name: "invalid body", token: token, body: `{"action":`, want: http.StatusBadRequest},
+		{name: "unknown module", token: token, body: `{"action":"on","module":"missing"}`, want: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, token: token, want: http.StatusMethodNotAllowed},
+		{name: "on", token: token, body: `{"action":"on"}`, want: http.StatusOK, wantOn: true},
+		{name: "toggle", token: token, body: `{"action":"toggle"}`, want: http.StatusOK, wantOn: false},
+		{name: "second relay", token: token, body: `{"action":"toggle","relay":2}`, want: http.StatusOK, wantOn: true},
+	}
+
+	// The control API does not use the basic auth users.
+	handler := newHandler(&WebConfig{BasicAuthUsers: map[string]string{"prometheus": dummyBcryptHash}})
+
+	originalLogger := slog.Default()
+	defer slog.SetDefault(originalLogger)
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = enabled
+			if tt.config != nil {
+				config = tt.config
+			}
+			method := http.MethodPost
+			if tt.method != "" {
+				method = tt.method
+			}
+			addr := target
+			if tt.target != "" {
+				addr = tt.target
+			}
+
+			req := httptest.NewRequest(method, "/api/v1/targets/"+addr+"/power", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			logs.Reset()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+			// Every attempt of an enabled control API is audited.
+			audited := strings.Contains(logs.String(), "component=audit")
+			if wantAudit := tt.config == nil && method == http.MethodPost; audited != wantAudit {
+				t.Errorf("audited = %t, want %t: %s", audited, wantAudit, logs.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var resp powerResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding response: %s", err)
+			}
+			if resp.On != tt.wantOn || resp.Target != target {
+				t.Errorf("unexpected response %+v", resp)
+			}
+		})
+	}
+}
+
+func TestSwitchPowerSingleRelay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("cmnd"); got != "Power1 off" {
+			t.Errorf("unexpected command %q", got)
+		}
+		fmt.Fprint(w, `{"POWER":"OFF"}`)
+	}))
+	defer srv.Close()
+
+	on, err := switchPower(context.Background(), strings.TrimPrefix(srv.URL, "http://"), ModuleConfig{}, 1, powerOff)
+	if err != nil || on {
+		t.Errorf("switchPower() = %t, %v, want false, nil", on, err)
+	}
+}
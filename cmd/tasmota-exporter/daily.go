@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxDailyTotals is how many days of closing totals are kept per target.
+const maxDailyTotals = 400
+
+const dateLayout = "2006-01-02"
+
+// dailyTotal is the energy used during a day, as reported by the plug.
+type dailyTotal struct {
+	// Date is the day on the format 2006-01-02.
+	Date string  `json:"date"`
+	KWh  float64 `json:"kwh"`
+}
+
+// recordDailyTotal detects when the plug has started a new day and
+// records the closing total of the previous day.
+//
+// The plug rolls Today into Yesterday at its own midnight, so when Today
+// decreases or Yesterday changes, Yesterday is the closing total of the
+// previous day. This does not depend on a probe landing in a specific
+// window, any probe after midnight will do.
+func recordDailyTotal(target string, tp TasmotaPlug, now time.Time) {
+	state.update(target, func(ts *targetState, known bool) {
+		defer func() {
+			ts.LastToday = tp.Today
+			ts.LastYesterday = tp.Yesterday
+		}()
+
+		if !known || (tp.Today >= ts.LastToday && tp.Yesterday == ts.LastYesterday) {
+			return
+		}
+
+		// The clock of the plug is not necessarily in sync with ours, so
+		// the rollover can be seen a bit before our midnight. Shifting by
+		// an hour attributes it to the right day as long as the clocks are
+		// less than an hour apart.
+		date := now.Add(time.Hour).AddDate(0, 0, -1).Format(dateLayout)
+
+		// Resetting the counters on the plug looks like a new day too, in
+		// that case the total already recorded for the day is overwritten.
+		if n := len(ts.Daily); n > 0 && ts.Daily[n-1].Date == date {
+			ts.Daily[n-1].KWh = tp.Yesterday
+			return
+		}
+
+		ts.Daily = append(ts.Daily, dailyTotal{Date: date, KWh: tp.Yesterday})
+		if len(ts.Daily) > maxDailyTotals {
+			ts.Daily = ts.Daily[len(ts.Daily)-maxDailyTotals:]
+		}
+	})
+}
+
+// dailyEnergyCollector exposes the closing total of the last complete
+// day of a target, with the day as a date label.
+//
+// The sample is not timestamped at the end of the day: Prometheus
+// rejects samples much older than its head, so it would be lost unless
+// scraped right after the plug rolls over.
+type dailyEnergyCollector struct {
+	target string
+	labels prometheus.Labels
+}
+
+func (c dailyEnergyCollector) desc() *prometheus.Desc {
+	return prometheus.NewDesc(
+		"tasmota_daily_energy_kwh",
+		"energy used during the last complete day in kilowatts hours (kWh), as reported by the tasmota plug, the day is in the date label",
+		[]string{"date"}, c.labels,
+	)
+}
+
+func (c dailyEnergyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc()
+}
+
+func (c dailyEnergyCollector) Collect(ch chan<- prometheus.Metric) {
+	state.mu.Lock()
+	ts, ok := state.Targets[c.target]
+	if !ok || len(ts.Daily) == 0 {
+		state.mu.Unlock()
+		return
+	}
+	last := ts.Daily[len(ts.Daily)-1]
+	state.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(c.desc(), prometheus.GaugeValue, last.KWh, last.Date)
+}
+
+// dailyHandler returns the recorded closing daily totals of a target as
+// JSON, or as CSV with format=csv.
+func dailyHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	state.mu.Lock()
+	ts, ok := state.Targets[target]
+	var totals []dailyTotal
+	if ok {
+		totals = append(totals, ts.Daily...)
+	}
+	state.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Unknown target, it has not been probed yet", http.StatusNotFound)
+		return
+	}
+
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Date < totals[j].Date })
+
+	switch format := params.Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if totals == nil {
+			totals = []dailyTotal{}
+		}
+		if err := json.NewEncoder(w).Encode(totals); err != nil {
+			slog.Error("failed to write daily response", "error", err)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"date", "kwh"})
+		for _, total := range totals {
+			cw.Write([]string{total.Date, strconv.FormatFloat(total.KWh, 'f', -1, 64)})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			slog.Error("failed to write daily response", "error", err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q, must be json or csv", format), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordDailyTotal(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	target := "test-target"
+
+	steps := []struct {
+		name string
+		time time.Time
+		plug TasmotaPlug
+	}{
+		{
+			name: "first probe",
+			time: time.Date(2024, 7, 26, 10, 0, 0, 0, time.UTC),
+			plug: TasmotaPlug{Today: 0.5, Yesterday: 1.1},
+		},
+		{
+			name: "same day",
+			time: time.Date(2024, 7, 26, 22, 0, 0, 0, time.UTC),
+			plug: TasmotaPlug{Today: 1.2, Yesterday: 1.1},
+		},
+		{
+			name: "plug rolled over before our midnight",
+			time: time.Date(2024, 7, 26, 23, 59, 50, 0, time.UTC),
+			plug: TasmotaPlug{Today: 0, Yesterday: 1.3},
+		},
+		{
+			name: "several hours after midnight on the next day",
+			time: time.Date(2024, 7, 28, 3, 0, 0, 0, time.UTC),
+			plug: TasmotaPlug{Today: 0.1, Yesterday: 2.4},
+		},
+		{
+			name: "no usage, nothing changes",
+			time: time.Date(2024, 7, 28, 8, 0, 0, 0, time.UTC),
+			plug: TasmotaPlug{Today: 0.1, Yesterday: 2.4},
+		},
+	}
+
+	for _, step := range steps {
+		recordDailyTotal(target, step.plug, step.time)
+	}
+
+	want := []dailyTotal{
+		{Date: "2024-07-26", KWh: 1.3},
+		{Date: "2024-07-27", KWh: 2.4},
+	}
+	if diff := cmp.Diff(want, state.Targets[target].Daily); diff != "" {
+		t.Errorf("unexpected daily totals (-want +got):\n%s", diff)
+	}
+
+	expected := `
+# HELP tasmota_daily_energy_kwh energy used during the last complete day in kilowatts hours (kWh), as reported by the tasmota plug, the day is in the date label
+# TYPE tasmota_daily_energy_kwh gauge
+tasmota_daily_energy_kwh{date="2024-07-27",target="test-target"} 2.4
+`
+	// The sample is not back-dated, Prometheus would reject it as out
+	// of bounds on scrapes later in the day.
+	collector := dailyEnergyCollector{target: target, labels: prometheus.Labels{"target": target}}
+	if err := promtest.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected daily energy metric: %s", err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(collector)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gathering metrics: %s", err)
+	}
+	if ts := mfs[0].GetMetric()[0].TimestampMs; ts != nil {
+		t.Errorf("daily energy sample has timestamp %d, want none", *ts)
+	}
+
+	rec := httptest.NewRecorder()
+	dailyHandler(rec, httptest.NewRequest(http.MethodGet, "/daily?target=test-target&format=csv", nil))
+	if got, want := rec.Body.String(), "date,kwh\n2024-07-26,1.3\n2024-07-27,2.4\n"; got != want {
+		t.Errorf("unexpected csv output:\n%s\nwant:\n%s", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	dailyHandler(rec, httptest.NewRequest(http.MethodGet, "/daily?target=test-target", nil))
+	if got, want := rec.Body.String(), `[{"date":"2024-07-26","kwh":1.3},{"date":"2024-07-27","kwh":2.4}]`+"\n"; got != want {
+		t.Errorf("unexpected json output:\n%s\nwant:\n%s", got, want)
+	}
+}
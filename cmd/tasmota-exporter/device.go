@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryConfig controls how requests to a plug are retried on transient
+// errors, like a dropped Wi-Fi packet. Retries are only made if the
+// backoff and the dial timeout fit within the probe deadline, a retry
+// without time left to connect would only load the plug.
+type RetryConfig struct {
+	// Attempts is how many times a request is made at most. Defaults
+	// to 1, which disables retries.
+	Attempts int `yaml:"attempts"`
+
+	// Backoff is how long to wait before the first retry, it doubles
+	// after every retry. Defaults to defaultRetryBackoff.
+	Backoff time.Duration `yaml:"backoff"`
+}
+
+const defaultRetryBackoff = 100 * time.Millisecond
+
+func (r RetryConfig) validate() error {
+	if r.Attempts < 0 {
+		return errors.New("attempts must be positive")
+	}
+	if r.Backoff < 0 {
+		return errors.New("backoff must be positive")
+	}
+
+	return nil
+}
+
+// statusError is returned when a plug answers with a status other than
+// 200 OK.
+type statusError struct {
+	status string
+	code   int
+}
+
+func (e statusError) Error() string {
+	return "unexpected status code from tasmota target: " + e.status
+}
+
+// isTransient reports if err is likely to go away when retrying the
+// request: timeouts, connections being refused or reset, and the plug
+// being temporarily unavailable.
+func isTransient(err error) bool {
+	var se statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusBadGateway ||
+			se.code == http.StatusServiceUnavailable ||
+			se.code == http.StatusGatewayTimeout
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// deviceResponse is a successful response from a plug.
+type deviceResponse struct {
+	body []byte
+
+	// tls is the state of the connection to the plug, nil if it is
+	// not served over HTTPS.
+	tls *tls.ConnectionState
+}
+
+// fetchDevice makes a GET request for path to the plug at target, as
+// configured by module. The request goes through the circuit breaker and
+// the device limits of the target, and is retried on transient errors.
+func fetchDevice(ctx context.Context, target string, module ModuleConfig, path string) (deviceResponse, error) {
+	breaker := breakers.get(target)
+	if err := breaker.allow(); err != nil {
+		loggerFrom(ctx).Debug("not querying plug", "error", err)
+		return deviceResponse{}, err
+	}
+
+	resp, err := fetchWithRetry(ctx, target, module, path)
+	breaker.record(err)
+
+	return resp, err
+}
+
+func fetchWithRetry(ctx context.Context, target string, module ModuleConfig, path string) (deviceResponse, error) {
+	attempts := max(config.Retry.Attempts, 1)
+	backoff := config.Retry.Backoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := fetchOnce(ctx, target, module, path)
+		if err == nil || attempt >= attempts || !isTransient(err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		// Do not start a retry which cannot finish before the deadline.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff+config.HTTPClient.dialTimeout() {
+			return deviceResponse{}, err
+		}
+
+		loggerFrom(ctx).Info("request to plug failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return deviceResponse{}, err
+		}
+		backoff *= 2
+	}
+}
+
+func fetchOnce(ctx context.Context, target string, module ModuleConfig, path string) (deviceResponse, error) {
+	client, err := deviceClients.get(module)
+	if err != nil {
+		return deviceResponse{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s", module.scheme(), target, path), nil)
+	if err != nil {
+		return deviceResponse{}, fmt.Errorf("creating request: %w", err)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, connectionTrace))
+
+	release, err := limiters.acquire(ctx, target)
+	if err != nil {
+		return deviceResponse{}, fmt.Errorf("throttled by device limits: %w", err)
+	}
+	defer release()
+
+	loggerFrom(ctx).Debug("making request to plug", "url", req.URL.String())
+	resp, err := client.Do(req)
+	if err != nil {
+		return deviceResponse{}, fmt.Errorf("failed to query tasmota target: %w", err)
+	}
+	defer resp.Body.Close()
+	loggerFrom(ctx).Debug("received response from plug", "status", resp.Status)
+
+	if resp.StatusCode != http.StatusOK {
+		// Drain the body so the connection can be reused.
+		io.Copy(io.Discard, resp.Body)
+
+		return deviceResponse{}, statusError{status: resp.Status, code: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return deviceResponse{}, fmt.Errorf("failed to read data from tasmota target: %w", err)
+	}
+
+	return deviceResponse{body: body, tls: resp.TLS}, nil
+}
+
+// HTTPClientConfig tunes the HTTP client shared by all requests to plugs.
+type HTTPClientConfig struct {
+	// MaxIdleConnsPerHost is how many idle connections are kept open to
+	// each plug. Defaults to 1, as plugs handle one connection at a time.
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+
+	// IdleConnTimeout is how long an idle connection is kept open.
+	// Defaults to defaultIdleConnTimeout.
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+
+	// DialTimeout is how long connecting to a plug may take. Defaults
+	// to defaultDialTimeout.
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+
+	// DisableKeepAlives closes the connection after every request, for
+	// firmware which does not handle keep-alive well.
+	DisableKeepAlives bool `yaml:"disable_keep_alives"`
+}
+
+const (
+	defaultIdleConnTimeout = 90 * time.Second
+	defaultDialTimeout     = 2 * time.Second
+)
+
+func (h HTTPClientConfig) validate() error {
+	if h.MaxIdleConnsPerHost < 0 {
+		return errors.New("max_idle_conns_per_host must be positive")
+	}
+	if h.IdleConnTimeout < 0 {
+		return errors.New("idle_conn_timeout must be positive")
+	}
+	if h.DialTimeout < 0 {
+		return errors.New("dial_timeout must be positive")
+	}
+
+	return nil
+}
+
+func (h HTTPClientConfig) dialTimeout() time.Duration {
+	if h.DialTimeout == 0 {
+		return defaultDialTimeout
+	}
+
+	return h.DialTimeout
+}
+
+var (
+	deviceConnectionsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_exporter_device_connections_total",
+		Help: "number of connections used for requests to tasmota plugs, by whether an idle connection was reused",
+	}, []string{"reused"})
+	deviceDialsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tasmota_exporter_device_dials_total",
+		Help: "number of connections opened to tasmota plugs",
+	})
+	deviceOpenConnectionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tasmota_exporter_device_open_connections",
+		Help: "number of connections currently open to tasmota plugs",
+	})
+)
+
+func init() {
+	exporterRegistry.MustRegister(deviceConnectionsCounter)
+	exporterRegistry.MustRegister(deviceDialsCounter)
+	exporterRegistry.MustRegister(deviceOpenConnectionsGauge)
+}
+
+var connectionTrace = &httptrace.ClientTrace{
+	GotConn: func(info httptrace.GotConnInfo) {
+		deviceConnectionsCounter.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+	},
+}
+
+// deviceClientPool holds the HTTP clients used for requests to plugs,
+// one per module. They are shared by all requests, so connections are
+// reused between probes.
+type deviceClientPool struct {
+	cfg HTTPClientConfig
+
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+var deviceClients = newDeviceClients(HTTPClientConfig{})
+
+func newDeviceClients(cfg HTTPClientConfig) *deviceClientPool {
+	return &deviceClientPool{
+		cfg:     cfg,
+		clients: make(map[string]*http.Client),
+	}
+}
+
+// get returns the client of module.
+func (p *deviceClientPool) get(module ModuleConfig) (*http.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.clients[module.name]; ok {
+		return c, nil
+	}
+
+	tlsConfig, err := module.TLSConfig.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("creating TLS config of module %s: %w", module.name, err)
+	}
+
+	proxyURL, err := parseProxyURL(module.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy URL of module %s: %w", module.name, err)
+	}
+
+	c := newDeviceClient(p.cfg, tlsConfig, proxyURL)
+	p.clients[module.name] = c
+
+	return c, nil
+}
+
+func newDeviceClient(cfg HTTPClientConfig, tlsConfig *tls.Config, proxyURL *url.URL) *http.Client {
+	maxIdleConnsPerHost := cfg.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = 1
+	}
+	idleConnTimeout := cfg.IdleConnTimeout
+	if idleConnTimeout == 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+	dialTimeout := cfg.dialTimeout()
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		deviceDialsCounter.Inc()
+		deviceOpenConnectionsGauge.Inc()
+
+		return &countedConn{Conn: conn}, nil
+	}
+	transport := &http.Transport{
+		DialContext:         dial,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: dialTimeout,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		DisableKeepAlives:   cfg.DisableKeepAlives,
+	}
+	// The proxy of the module replaces the one of the environment,
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honoured like by
+	// http.DefaultTransport otherwise.
+	if proxyURL != nil {
+		transport.DialContext = proxyDial(proxyURL, dial)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	return &http.Client{
+		Timeout:   probeTimeout,
+		Transport: transport,
+	}
+}
+
+// countedConn decrements the open connections gauge when closed.
+type countedConn struct {
+	net.Conn
+	closeOnce sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(deviceOpenConnectionsGauge.Dec)
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchDeviceRetry(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	tests := []struct {
+		name         string
+		retry        RetryConfig
+		failures     int32
+		failStatus   int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{
+			name:         "no retries by default",
+			failures:     1,
+			failStatus:   http.StatusServiceUnavailable,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "transient error is retried",
+			retry:        RetryConfig{Attempts: 3, Backoff: time.Millisecond},
+			failures:     2,
+			failStatus:   http.StatusServiceUnavailable,
+			wantRequests: 3,
+		},
+		{
+			name:         "gives up after attempts",
+			retry:        RetryConfig{Attempts: 2, Backoff: time.Millisecond},
+			failures:     5,
+			failStatus:   http.StatusGatewayTimeout,
+			wantErr:      true,
+			wantRequests: 2,
+		},
+		{
+			name:         "not found is not retried",
+			retry:        RetryConfig{Attempts: 3, Backoff: time.Millisecond},
+			failures:     1,
+			failStatus:   http.StatusNotFound,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config = &Config{Retry: tt.retry}
+
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				w.Write([]byte(fakePlugPage))
+			}))
+			defer srv.Close()
+
+			_, err := fetchDevice(context.Background(), strings.TrimPrefix(srv.URL, "http://"), ModuleConfig{}, "?m")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetchDevice() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestFetchDeviceRetryRespectsDeadline(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = &Config{Retry: RetryConfig{Attempts: 5, Backoff: time.Second}}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := fetchDevice(ctx, strings.TrimPrefix(srv.URL, "http://"), ModuleConfig{}, "?m"); err == nil {
+		t.Errorf("expected fetchDevice() to fail")
+	}
+
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("fetchDevice() took %s, expected it to not wait for a retry past the deadline", elapsed)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+
+	// The backoff fits, but there would be no time left to connect.
+	config = &Config{
+		Retry:      RetryConfig{Attempts: 5, Backoff: time.Millisecond},
+		HTTPClient: HTTPClientConfig{DialTimeout: time.Second},
+	}
+	requests.Store(0)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := fetchDevice(ctx, strings.TrimPrefix(srv.URL, "http://"), ModuleConfig{}, "?m"); err == nil {
+		t.Errorf("expected fetchDevice() to fail")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("requests without time to connect = %d, want 1", got)
+	}
+}
+
+// newCountingPlug starts a fake plug which counts the connections
+// opened to it.
+func newCountingPlug(tb testing.TB) (string, *atomic.Int32) {
+	tb.Helper()
+
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fakePlugPage))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	tb.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://"), &conns
+}
+
+func TestDeviceClientConnectionReuse(t *testing.T) {
+	originalClients := deviceClients
+	defer func() { deviceClients = originalClients }()
+
+	tests := []struct {
+		name      string
+		cfg       HTTPClientConfig
+		wantConns int32
+	}{
+		{
+			name:      "keep-alive",
+			wantConns: 1,
+		},
+		{
+			name:      "keep-alive disabled",
+			cfg:       HTTPClientConfig{DisableKeepAlives: true},
+			wantConns: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceClients = newDeviceClients(tt.cfg)
+			target, conns := newCountingPlug(t)
+
+			for range 5 {
+				if _, err := fetchDevice(context.Background(), target, ModuleConfig{}, "?m"); err != nil {
+					t.Fatalf("fetchDevice() error = %s", err)
+				}
+			}
+
+			if got := conns.Load(); got != tt.wantConns {
+				t.Errorf("connections = %d, want %d", got, tt.wantConns)
+			}
+		})
+	}
+}
+
+func TestDeviceClientProxy(t *testing.T) {
+	// Without a proxy in the module, the proxy of the environment is
+	// used like by http.DefaultTransport.
+	transport := newDeviceClient(HTTPClientConfig{}, nil, nil).Transport.(*http.Transport)
+	if transport.Proxy == nil {
+		t.Error("expected the proxy of the environment to be used")
+	}
+
+	proxyURL, err := url.Parse("socks5://127.0.0.1:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport = newDeviceClient(HTTPClientConfig{}, nil, proxyURL).Transport.(*http.Transport)
+	if transport.Proxy != nil {
+		t.Error("expected the proxy of the module to replace the one of the environment")
+	}
+}
+
+// BenchmarkFetchDevice compares the shared client with creating a client
+// per request, which is what the exporter used to do.
+func BenchmarkFetchDevice(b *testing.B) {
+	b.Run("shared-client", func(b *testing.B) {
+		target, conns := newCountingPlug(b)
+		clients := deviceClients
+		defer func() { deviceClients = clients }()
+		deviceClients = newDeviceClients(HTTPClientConfig{})
+
+		for range b.N {
+			if _, err := fetchDevice(context.Background(), target, ModuleConfig{}, "?m"); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	})
+
+	b.Run("client-per-request", func(b *testing.B) {
+		target, conns := newCountingPlug(b)
+
+		for range b.N {
+			client := http.Client{Timeout: probeTimeout, Transport: &http.Transport{}}
+			resp, err := client.Get("http://" + target + "?m")
+			if err != nil {
+				b.Fatal(err)
+			}
+			io.ReadAll(resp.Body)
+		}
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DeviceTLSConfig controls how the certificates of plugs served over
+// HTTPS are verified, e.g. ESP32 builds with TLS or plugs behind a
+// reverse proxy.
+type DeviceTLSConfig struct {
+	// CAFile is a PEM bundle of the CAs the certificates of plugs are
+	// verified against, instead of the system CAs.
+	CAFile string `yaml:"ca_file"`
+
+	// ServerName is the name the certificates are verified against,
+	// instead of the host of the target.
+	ServerName string `yaml:"server_name"`
+
+	// InsecureSkipVerify disables verifying the certificates.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	// FingerprintsSHA256 pins the certificates of plugs, typically
+	// self-signed, by the hex SHA-256 fingerprint of the leaf
+	// certificate. If set, a certificate is accepted if and only if it
+	// matches one of them, without verifying its chain.
+	FingerprintsSHA256 []string `yaml:"fingerprints_sha256"`
+}
+
+// tlsConfig returns the TLS client configuration for requests to plugs.
+func (t DeviceTLSConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca_file: %w", err)
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_file %s", t.CAFile)
+		}
+	}
+
+	if len(t.FingerprintsSHA256) == 0 {
+		return cfg, nil
+	}
+
+	pins := make(map[[sha256.Size]byte]bool)
+	for _, fp := range t.FingerprintsSHA256 {
+		sum, err := hex.DecodeString(strings.ReplaceAll(fp, ":", ""))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", fp)
+		}
+		pins[[sha256.Size]byte(sum)] = true
+	}
+
+	// The pins replace the verification of the chain, which fails for
+	// self-signed certificates.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("plug did not present a certificate")
+		}
+
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		if !pins[sum] {
+			return fmt.Errorf("certificate fingerprint %x does not match any pinned fingerprint", sum)
+		}
+
+		return nil
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFetchDeviceHTTPS(t *testing.T) {
+	originalClients := deviceClients
+	defer func() { deviceClients = originalClients }()
+	deviceClients = newDeviceClients(HTTPClientConfig{})
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fakePlugPage)
+	}))
+	defer srv.Close()
+	target := strings.TrimPrefix(srv.URL, "https://")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	// Fingerprints are often copied with colons between the bytes.
+	var colons []string
+	for _, b := range sum {
+		colons = append(colons, fmt.Sprintf("%02X", b))
+	}
+
+	tests := []struct {
+		name    string
+		tls     DeviceTLSConfig
+		wantErr bool
+	}{
+		{name: "system CAs", wantErr: true},
+		{name: "custom CA", tls: DeviceTLSConfig{CAFile: caFile}},
+		{name: "custom CA with wrong server name", tls: DeviceTLSConfig{CAFile: caFile, ServerName: "plug.local"}, wantErr: true},
+		{name: "insecure skip verify", tls: DeviceTLSConfig{InsecureSkipVerify: true}},
+		{name: "pinned", tls: DeviceTLSConfig{FingerprintsSHA256: []string{fingerprint}}},
+		{name: "pinned with colons", tls: DeviceTLSConfig{FingerprintsSHA256: []string{strings.Join(colons, ":")}}},
+		{name: "pinned to another certificate", tls: DeviceTLSConfig{FingerprintsSHA256: []string{strings.Repeat("ab", sha256.Size)}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := ModuleConfig{Scheme: "https", TLSConfig: tt.tls, name: tt.name}
+			if err := module.validate(); err != nil {
+				t.Fatalf("validating module: %s", err)
+			}
+
+			resp, err := fetchDevice(context.Background(), target, module, "?m")
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected the request to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchDevice() error = %s", err)
+			}
+			if resp.tls == nil {
+				t.Fatal("expected the TLS state of the connection")
+			}
+
+			reg := prometheus.NewRegistry()
+			registerProbeMetrics(reg, probeResult{target: target, plug: parse(string(resp.body)), tls: resp.tls}, nil)
+			families, err := reg.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := float64(srv.Certificate().NotAfter.Unix())
+			found := false
+			for _, f := range families {
+				if f.GetName() == "probe_ssl_earliest_cert_expiry" {
+					found = true
+					if got := f.GetMetric()[0].GetGauge().GetValue(); got != want {
+						t.Errorf("probe_ssl_earliest_cert_expiry = %v, want %v", got, want)
+					}
+				}
+			}
+			if !found {
+				t.Error("expected probe_ssl_earliest_cert_expiry")
+			}
+		})
+	}
+}
+
+func TestModuleTLSValidate(t *testing.T) {
+	for _, m := range []ModuleConfig{
+		{Scheme: "ftp"},
+		{Scheme: "https", TLSConfig: DeviceTLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}},
+		{Scheme: "https", TLSConfig: DeviceTLSConfig{FingerprintsSHA256: []string{"abcd"}}},
+	} {
+		if err := m.validate(); err == nil {
+			t.Errorf("expected an error for %+v", m)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// energyPeriods are the calendar periods energy usage is accumulated
+// for, in the configured timezone.
+var energyPeriods = []string{"day", "week", "month", "year"}
+
+// periodEnergy is the energy used during a calendar period.
+type periodEnergy struct {
+	Start time.Time `json:"start"`
+	KWh   float64   `json:"kwh"`
+}
+
+// periodStart returns the start of the calendar period containing t,
+// weeks start on Monday.
+func periodStart(period string, t time.Time) time.Time {
+	year, month, day := t.Date()
+
+	switch period {
+	case "day":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case "week":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case "year":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+
+	panic("unknown energy period: " + period)
+}
+
+// currentPeriods returns the energy used in the periods containing now,
+// periods which have ended since the last probe are reported as zero.
+func (ts *targetState) currentPeriods(now time.Time) map[string]periodEnergy {
+	ret := make(map[string]periodEnergy, len(energyPeriods))
+	for _, period := range energyPeriods {
+		start := periodStart(period, now)
+
+		pe := periodEnergy{Start: start}
+		if stored, ok := ts.Periods[period]; ok && stored.Start.Equal(start) {
+			pe.KWh = stored.KWh
+		}
+		ret[period] = pe
+	}
+
+	return ret
+}
+
+// recordEnergy updates the state of target with the Total counter from
+// a probe at now: the energy used since the previous probe is added to
+// the calendar periods and, if a tariff is given, priced at the current
+// rate. Probes are frequent enough that the whole delta can be
+// attributed to the current period and rate. It returns a copy of the
+// updated state.
+func recordEnergy(tariff *TariffConfig, target string, total float64, now time.Time) targetState {
+	var ret targetState
+	state.update(target, func(ts *targetState, known bool) {
+		delta := total - ts.LastTotal
+		if total < ts.LastTotal {
+			// The counter has been reset, all of the energy
+			// on the counter has been used since then.
+			delta = total
+		}
+		if !known {
+			delta = 0
+		}
+
+		ts.Periods = ts.currentPeriods(now)
+		for period, pe := range ts.Periods {
+			pe.KWh += delta
+			ts.Periods[period] = pe
+		}
+
+		if tariff != nil {
+			ts.Cost += delta * tariff.priceAt(now)
+		}
+		ts.LastTotal = total
+
+		ret = *ts
+	})
+
+	return ret
+}
+
+// energyHandler returns the energy used in the current calendar periods
+// as JSON, for the target given as parameter or all known targets.
+func energyHandler(w http.ResponseWriter, r *http.Request) {
+	now := getNow().In(config.Location())
+	target := r.URL.Query().Get("target")
+
+	ret := make(map[string]map[string]periodEnergy)
+	state.mu.Lock()
+	for name, ts := range state.Targets {
+		if target != "" && name != target {
+			continue
+		}
+		ret[name] = ts.currentPeriods(now)
+	}
+	state.mu.Unlock()
+
+	if target != "" && len(ret) == 0 {
+		http.Error(w, "Unknown target, it has not been probed yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		slog.Error("failed to write energy response", "error", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 7, 24, 15, 4, 5, 0, loc) // Wednesday
+
+	tests := []struct {
+		period string
+		want   time.Time
+	}{
+		{period: "day", want: time.Date(2024, 7, 24, 0, 0, 0, 0, loc)},
+		{period: "week", want: time.Date(2024, 7, 22, 0, 0, 0, 0, loc)},
+		{period: "month", want: time.Date(2024, 7, 1, 0, 0, 0, 0, loc)},
+		{period: "year", want: time.Date(2024, 1, 1, 0, 0, 0, 0, loc)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			if got := periodStart(tt.period, now); !got.Equal(tt.want) {
+				t.Errorf("periodStart(%s) = %s, want %s", tt.period, got, tt.want)
+			}
+		})
+	}
+
+	// Sunday belongs to the week starting on the Monday before.
+	sunday := time.Date(2024, 7, 28, 23, 0, 0, 0, loc)
+	if got, want := periodStart("week", sunday), time.Date(2024, 7, 22, 0, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("periodStart(week) on Sunday = %s, want %s", got, want)
+	}
+}
+
+func TestRecordEnergyCost(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	tariff := &TariffConfig{
+		Currency: "EUR",
+		Price:    0.30,
+		Rates: []TariffRate{
+			{Name: "night", Start: "22:00", End: "06:00", Price: 0.10},
+		},
+	}
+	if err := tariff.validate(); err != nil {
+		t.Fatalf("validate() error = %s", err)
+	}
+
+	day := time.Date(2024, 7, 24, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 7, 24, 23, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name  string
+		total float64
+		time  time.Time
+		want  float64
+	}{
+		{name: "first probe has nothing to compare to", total: 100, time: day, want: 0},
+		{name: "day usage", total: 102, time: day, want: 0.60},
+		{name: "night usage", total: 107, time: night, want: 1.10},
+		{name: "counter reset", total: 1, time: night, want: 1.20},
+	}
+
+	for _, step := range steps {
+		got := recordEnergy(tariff, "plug", step.total, step.time).Cost
+		if math.Abs(got-step.want) > 1e-9 {
+			t.Errorf("%s: cost = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestRecordEnergyPeriods(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	endOfMonth := time.Date(2024, 7, 31, 23, 0, 0, 0, time.UTC) // Wednesday
+	startOfMonth := time.Date(2024, 8, 1, 1, 0, 0, 0, time.UTC)
+
+	recordEnergy(nil, "plug", 10, endOfMonth)
+	got := recordEnergy(nil, "plug", 12, endOfMonth)
+	for _, period := range energyPeriods {
+		if got.Periods[period].KWh != 2 {
+			t.Errorf("%s = %v, want 2", period, got.Periods[period].KWh)
+		}
+	}
+
+	got = recordEnergy(nil, "plug", 13, startOfMonth)
+	want := map[string]float64{"day": 1, "week": 3, "month": 1, "year": 3}
+	for period, kwh := range want {
+		if got.Periods[period].KWh != kwh {
+			t.Errorf("%s = %v, want %v", period, got.Periods[period].KWh, kwh)
+		}
+	}
+}
+
+func TestEnergyHandler(t *testing.T) {
+	originalState := state
+	originalNowFunc := getNow
+	defer func() {
+		state = originalState
+		getNow = originalNowFunc
+	}()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	now := time.Date(2024, 7, 24, 12, 0, 0, 0, time.Local)
+	getNow = func() time.Time { return now }
+
+	recordEnergy(nil, "plug", 1, now)
+	recordEnergy(nil, "plug", 1.5, now)
+
+	rec := httptest.NewRecorder()
+	energyHandler(rec, httptest.NewRequest(http.MethodGet, "/energy?target=plug", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got map[string]map[string]periodEnergy
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if got["plug"]["month"].KWh != 0.5 {
+		t.Errorf("month = %v, want 0.5", got["plug"]["month"].KWh)
+	}
+
+	rec = httptest.NewRecorder()
+	energyHandler(rec, httptest.NewRequest(http.MethodGet, "/energy?target=unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// EventsConfig sends notifications when something changes on a plug,
+// like it going offline. Events are detected on every probe, whether
+// made on request or by the background poller.
+type EventsConfig struct {
+	// OfflineAfter is how many probes in a row must fail before a plug
+	// is offline. Defaults to 1.
+	OfflineAfter int `yaml:"offline_after"`
+
+	// Webhooks are the URLs the events are posted to.
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+func (c *EventsConfig) validate() error {
+	if c.OfflineAfter < 0 {
+		return errors.New("offline_after must be positive")
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].validate(); err != nil {
+			return fmt.Errorf("webhook %d: %w", i, err)
+		}
+		if names[c.Webhooks[i].Name] {
+			return fmt.Errorf("webhook %d: duplicate name %q", i, c.Webhooks[i].Name)
+		}
+		names[c.Webhooks[i].Name] = true
+	}
+
+	return nil
+}
+
+// eventType is what happened to a plug.
+type eventType string
+
+const (
+	// eventOffline is when probes of a plug start failing.
+	eventOffline eventType = "offline"
+
+	// eventOnline is when an offline plug answers again.
+	eventOnline eventType = "online"
+
+	// eventRelayChanged is when the relay of a plug is switched.
+	eventRelayChanged eventType = "relay_changed"
+
+	// eventCounterReset is when the Total counter of a plug goes
+	// down, e.g. after it was reset from its web interface.
+	eventCounterReset eventType = "counter_reset"
+
+	// eventApplianceFinished is when the appliance plugged into a
+	// target finishes a cycle.
+	eventApplianceFinished eventType = "appliance_finished"
+)
+
+var eventTypes = []eventType{eventOffline, eventOnline, eventRelayChanged, eventCounterReset, eventApplianceFinished}
+
+// event is something that happened to a plug. It is the default body of
+// webhooks, and what their templates are executed with.
+type event struct {
+	Type   eventType `json:"type"`
+	Target string    `json:"target"`
+	Time   time.Time `json:"time"`
+
+	// Error is why the last probe failed, for offline events.
+	Error string `json:"error,omitempty"`
+
+	// Plug is the reading of the probe which raised the event, unset
+	// for offline events.
+	Plug *TasmotaPlug `json:"plug,omitempty"`
+
+	// Cycle is the cycle which finished, for appliance_finished events.
+	Cycle *applianceCycle `json:"cycle,omitempty"`
+}
+
+// applianceCycle is a finished cycle of an appliance.
+type applianceCycle struct {
+	EnergyKWh       float64 `json:"energy_kwh"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+var (
+	eventsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_exporter_events_total",
+		Help: "number of events detected on tasmota plugs, by type",
+	}, []string{"type"})
+	eventsDroppedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_exporter_events_dropped_total",
+		Help: "number of events dropped because a subscriber, like a webhook, was too far behind",
+	}, []string{"subscriber"})
+)
+
+func init() {
+	exporterRegistry.MustRegister(eventsCounter)
+	exporterRegistry.MustRegister(eventsDroppedCounter)
+}
+
+// eventQueueSize is how many events a subscriber can lag behind before
+// events are dropped for it.
+const eventQueueSize = 100
+
+// targetEvents is what is known about a target to detect changes.
+type targetEvents struct {
+	// seen is set once a probe of the target succeeded, changes are
+	// only detected from there.
+	seen    bool
+	offline bool
+
+	failures int
+	on       bool
+	total    float64
+	cycles   int
+}
+
+// eventBus detects events from the results of probes and hands them
+// to its subscribers.
+type eventBus struct {
+	offlineAfter int
+
+	mu          sync.Mutex
+	targets     map[string]*targetEvents
+	subscribers []eventSubscriber
+	closed      bool
+}
+
+type eventSubscriber struct {
+	name string
+	ch   chan event
+}
+
+var events *eventBus
+
+func newEventBus(cfg *EventsConfig) *eventBus {
+	return &eventBus{
+		offlineAfter: max(cfg.OfflineAfter, 1),
+		targets:      make(map[string]*targetEvents),
+	}
+}
+
+// subscribe returns a channel receiving the events published from now
+// on. It is closed by close. The name identifies the subscriber in
+// metrics.
+func (b *eventBus) subscribe(name string) <-chan event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan event, eventQueueSize)
+	b.subscribers = append(b.subscribers, eventSubscriber{name: name, ch: ch})
+
+	return ch
+}
+
+// publish hands ev to every subscriber without blocking, the event is
+// dropped for subscribers whose queue is full.
+func (b *eventBus) publish(ev event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	eventsCounter.WithLabelValues(string(ev.Type)).Inc()
+	for _, sub := range b.subscribers {
+		select {
+		case sub.ch <- ev:
+		default:
+			eventsDroppedCounter.WithLabelValues(sub.name).Inc()
+		}
+	}
+}
+
+// close closes the channels of the subscribers, events published
+// afterwards are ignored.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, sub := range b.subscribers {
+		close(sub.ch)
+	}
+}
+
+// observe detects the events raised by the result of a probe, by
+// comparing it to the previous results of the same target, and
+// publishes them. It does nothing on a nil bus.
+func (b *eventBus) observe(res probeResult) {
+	if b == nil {
+		return
+	}
+
+	for _, ev := range b.detect(res) {
+		b.publish(ev)
+	}
+}
+
+func (b *eventBus) detect(res probeResult) []event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.targets[res.target]
+	if !ok {
+		t = &targetEvents{}
+		b.targets[res.target] = t
+	}
+
+	base := event{Target: res.target, Time: getNow()}
+	with := func(typ eventType) event {
+		ev := base
+		ev.Type = typ
+		return ev
+	}
+
+	if res.err != nil {
+		t.failures++
+		if !t.seen || t.offline || t.failures < b.offlineAfter {
+			return nil
+		}
+
+		t.offline = true
+		ev := with(eventOffline)
+		ev.Error = res.err.Error()
+		return []event{ev}
+	}
+
+	plug := res.plug
+	base.Plug = &plug
+
+	var ret []event
+	if t.seen {
+		if t.offline {
+			ret = append(ret, with(eventOnline))
+		}
+		if plug.On != t.on {
+			ret = append(ret, with(eventRelayChanged))
+		}
+		if plug.Total < t.total {
+			ret = append(ret, with(eventCounterReset))
+		}
+	}
+
+	cycles := t.cycles
+	if a := res.state.Appliance; a != nil {
+		// Cycles finished before the first probe since the exporter
+		// started are not reported again.
+		if t.seen && a.Cycles > t.cycles {
+			ev := with(eventApplianceFinished)
+			ev.Cycle = &applianceCycle{
+				EnergyKWh:       a.LastCycleEnergy,
+				DurationSeconds: a.LastCycleDuration.Seconds(),
+			}
+			ret = append(ret, ev)
+		}
+		cycles = a.Cycles
+	}
+
+	*t = targetEvents{
+		seen:   true,
+		on:     plug.On,
+		total:  plug.Total,
+		cycles: cycles,
+	}
+
+	return ret
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEventBusDetect(t *testing.T) {
+	originalNowFunc := getNow
+	defer func() { getNow = originalNowFunc }()
+	now := time.Date(2024, 1, 15, 20, 0, 0, 0, time.UTC)
+	getNow = func() time.Time { return now }
+
+	bus := newEventBus(&EventsConfig{OfflineAfter: 2})
+
+	ok := func(on bool, total float64, cycles int) probeResult {
+		res := probeResult{target: "washer.local", plug: TasmotaPlug{On: on, Total: total}}
+		if cycles > 0 {
+			res.state.Appliance = &applianceState{Cycles: cycles, LastCycleEnergy: 0.8, LastCycleDuration: time.Hour}
+		}
+		return res
+	}
+	failed := probeResult{target: "washer.local", err: errors.New("timeout")}
+
+	steps := []struct {
+		res  probeResult
+		want []eventType
+	}{
+		// Nothing is known about the target before its first success.
+		{res: failed},
+		{res: failed},
+		{res: ok(true, 10, 1)},
+		{res: ok(true, 11, 1)},
+		{res: ok(false, 11, 2), want: []eventType{eventRelayChanged, eventApplianceFinished}},
+		{res: failed},
+		{res: failed, want: []eventType{eventOffline}},
+		{res: failed},
+		{res: ok(false, 0.1, 2), want: []eventType{eventOnline, eventCounterReset}},
+		// A single failure is not enough to be offline.
+		{res: failed},
+		{res: ok(false, 0.2, 2)},
+	}
+
+	for i, step := range steps {
+		var got []eventType
+		for _, ev := range bus.detect(step.res) {
+			got = append(got, ev.Type)
+		}
+		if diff := cmp.Diff(step.want, got); diff != "" {
+			t.Errorf("step %d: unexpected events (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func TestEventBusEvents(t *testing.T) {
+	originalNowFunc := getNow
+	defer func() { getNow = originalNowFunc }()
+	now := time.Date(2024, 1, 15, 20, 0, 0, 0, time.UTC)
+	getNow = func() time.Time { return now }
+
+	bus := newEventBus(&EventsConfig{})
+	bus.detect(probeResult{target: "washer.local", state: targetState{Appliance: &applianceState{}}})
+
+	evs := bus.detect(probeResult{
+		target: "washer.local",
+		plug:   TasmotaPlug{Total: 3},
+		state:  targetState{Appliance: &applianceState{Cycles: 1, LastCycleEnergy: 0.8, LastCycleDuration: time.Hour}},
+	})
+	want := []event{{
+		Type:   eventApplianceFinished,
+		Target: "washer.local",
+		Time:   now,
+		Plug:   &TasmotaPlug{Total: 3},
+		Cycle:  &applianceCycle{EnergyKWh: 0.8, DurationSeconds: 3600},
+	}}
+	if diff := cmp.Diff(want, evs); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+
+	evs = bus.detect(probeResult{target: "washer.local", err: errors.New("timeout")})
+	want = []event{{Type: eventOffline, Target: "washer.local", Time: now, Error: "timeout"}}
+	if diff := cmp.Diff(want, evs); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+}
+
+func TestEventBusPublish(t *testing.T) {
+	bus := newEventBus(&EventsConfig{})
+	fast := bus.subscribe("fast")
+	slow := bus.subscribe("slow")
+
+	dropped := promtest.ToFloat64(eventsDroppedCounter.WithLabelValues("slow"))
+
+	for range eventQueueSize {
+		bus.publish(event{Type: eventOnline})
+		<-fast
+	}
+	bus.publish(event{Type: eventOffline})
+
+	if got := (<-fast).Type; got != eventOffline {
+		t.Errorf("fast subscriber got %s, want %s", got, eventOffline)
+	}
+	if got := promtest.ToFloat64(eventsDroppedCounter.WithLabelValues("slow")) - dropped; got != 1 {
+		t.Errorf("dropped events = %v, want 1", got)
+	}
+
+	bus.close()
+	bus.publish(event{Type: eventOnline})
+	if _, ok := <-fast; ok {
+		t.Error("expected the channel to be closed")
+	}
+	if got := len(slow); got != eventQueueSize {
+		t.Errorf("queued events = %d, want %d", got, eventQueueSize)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// probeHistorySize is how many probes are shown on the landing page.
+const probeHistorySize = 100
+
+// probeHistoryEntry is a probe shown on the landing page.
+type probeHistoryEntry struct {
+	Time     time.Time
+	Target   string
+	Module   string
+	Duration time.Duration
+	Success  bool
+	Reason   string
+}
+
+// probeHistory keeps the last probes made to plugs.
+type probeHistory struct {
+	mu      sync.Mutex
+	entries []probeHistoryEntry
+	next    int
+}
+
+var history = &probeHistory{}
+
+func (h *probeHistory) add(res probeResult) {
+	entry := probeHistoryEntry{
+		Time:     getNow(),
+		Target:   res.target,
+		Module:   res.module,
+		Duration: res.duration,
+		Success:  res.err == nil,
+	}
+	if res.err != nil {
+		entry.Reason = res.err.Error()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) < probeHistorySize {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % probeHistorySize
+}
+
+// list returns the probes, newest first.
+func (h *probeHistory) list() []probeHistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ret := make([]probeHistoryEntry, 0, len(h.entries))
+	for i := range h.entries {
+		idx := (h.next - 1 - i + 2*len(h.entries)) % len(h.entries)
+		ret = append(ret, h.entries[idx])
+	}
+
+	return ret
+}
+
+// probeDebug collects what happened during a probe, for the debug
+// output of /probe.
+type probeDebug struct {
+	mu   sync.Mutex
+	logs bytes.Buffer
+	raw  []byte
+}
+
+func (d *probeDebug) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.logs.Write(p)
+}
+
+type probeDebugKey struct{}
+
+// debugRaw stores the raw response of the plug in the debug output of
+// the probe, if it has been requested.
+func debugRaw(ctx context.Context, raw []byte) {
+	d, ok := ctx.Value(probeDebugKey{}).(*probeDebug)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.raw = raw
+}
+
+// serveProbeDebug probes target and writes what happened instead of
+// metrics. It always queries the plug, bypassing the background poller.
+// The logs of the probe are captured at debug level regardless of the
+// configured level.
+func serveProbeDebug(w http.ResponseWriter, r *http.Request, target TargetConfig) {
+	d := &probeDebug{}
+	logger := slog.New(teeHandler{
+		slog.Default().Handler(),
+		slog.NewTextHandler(d, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	})
+
+	ctx := context.WithValue(r.Context(), probeDebugKey{}, d)
+	res := runProbe(withLogger(ctx, logger), target)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(w, "Logs for the probe:\n%s", d.logs.String())
+	if res.err != nil {
+		fmt.Fprintf(w, "Probe failed: %s\n", res.err)
+	} else {
+		fmt.Fprintf(w, "Probe succeeded\n")
+	}
+
+	fmt.Fprintf(w, "\n\nRaw response from the plug:\n%s\n", d.raw)
+
+	if res.err == nil {
+		parsed, err := json.MarshalIndent(res.plug, "", "  ")
+		if err != nil {
+			slog.Error("failed to encode parsed plug", "error", err)
+		}
+		fmt.Fprintf(w, "\n\nParsed plug:\n%s\n", parsed)
+	}
+}
+
+var landingTemplate = template.Must(template.New("landing").Funcs(template.FuncMap{
+	"debugURL": func(e probeHistoryEntry) string {
+		return "/probe?" + url.Values{"target": {e.Target}, "module": {e.Module}, "debug": {"true"}}.Encode()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Tasmota Exporter</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+.failure { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Tasmota Exporter</h1>
+<p><a href="/metrics">Metrics</a></p>
+<h2>Recent probes</h2>
+<table>
+<tr><th>Time</th><th>Target</th><th>Module</th><th>Duration</th><th>Result</th><th>Reason</th><th>Debug</th></tr>
+{{range .}}<tr>
+<td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.Target}}</td>
+<td>{{.Module}}</td>
+<td>{{.Duration}}</td>
+{{if .Success}}<td>Success</td>{{else}}<td class="failure">Failure</td>{{end}}
+<td>{{.Reason}}</td>
+<td><a href="{{debugURL .}}">Debug probe</a></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// landingHandler renders the recent probes.
+func landingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := landingTemplate.Execute(w, history.list()); err != nil {
+		slog.Error("failed to render landing page", "error", err)
+	}
+}
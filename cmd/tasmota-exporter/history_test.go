@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeHistory(t *testing.T) {
+	h := &probeHistory{}
+
+	for i := range probeHistorySize + 5 {
+		h.add(probeResult{target: fmt.Sprintf("plug-%d", i), module: "web"})
+	}
+
+	entries := h.list()
+	if len(entries) != probeHistorySize {
+		t.Fatalf("history has %d entries, want %d", len(entries), probeHistorySize)
+	}
+
+	if got, want := entries[0].Target, fmt.Sprintf("plug-%d", probeHistorySize+4); got != want {
+		t.Errorf("newest entry = %s, want %s", got, want)
+	}
+	if got, want := entries[len(entries)-1].Target, "plug-5"; got != want {
+		t.Errorf("oldest entry = %s, want %s", got, want)
+	}
+}
+
+func TestLandingPage(t *testing.T) {
+	originalHistory := history
+	defer func() { history = originalHistory }()
+	history = &probeHistory{}
+
+	history.add(probeResult{target: "kitchen", module: "web"})
+	history.add(probeResult{target: "office", module: "status", err: errors.New("failed to query tasmota target: i/o timeout")})
+
+	rec := httptest.NewRecorder()
+	landingHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"<td>kitchen</td>",
+		"<td>office</td>",
+		"failed to query tasmota target: i/o timeout",
+		`href="/probe?debug=true&amp;module=status&amp;target=office"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected landing page to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	landingHandler(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status for unknown path = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestProbeDebug(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	target := newFakePlug(t)
+
+	rec := httptest.NewRecorder()
+	tasmotaHandler(rec, httptest.NewRequest(http.MethodGet, "/probe?debug=true&target="+target, nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`level=DEBUG msg="beginning probe" target=` + target + " module=web",
+		`status="200 OK"`,
+		"Probe succeeded",
+		"{s}Voltage{m}",
+		`"Voltage": 237`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected debug output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"tailscale.com/envknob"
+)
+
+var overrideInfluxToken = envknob.String("TASMOTA_EXPORTER_INFLUX_TOKEN")
+
+// InfluxConfig controls how plugs are rendered as InfluxDB line
+// protocol on /influx, and optionally pushes them to InfluxDB.
+type InfluxConfig struct {
+	// Measurement is the measurement of the lines. Defaults to
+	// defaultInfluxMeasurement.
+	Measurement string `yaml:"measurement"`
+
+	// TargetTag is the tag holding the address of the target.
+	// Defaults to target.
+	TargetTag string `yaml:"target_tag"`
+
+	// Tags are added to every line.
+	Tags map[string]string `yaml:"tags"`
+
+	// Fields maps the names of the fields to the values of the plug
+	// they hold, see influxValues. Defaults to every value under its
+	// own name.
+	Fields map[string]string `yaml:"fields"`
+
+	// Push writes the configured targets to InfluxDB periodically.
+	Push *InfluxPushConfig `yaml:"push"`
+}
+
+// InfluxPushConfig writes the targets of the configuration file to the
+// write API of InfluxDB v2.
+type InfluxPushConfig struct {
+	// URL is the base URL of InfluxDB, e.g. http://influxdb:8086.
+	URL string `yaml:"url"`
+
+	// Org and Bucket are where the points are written.
+	Org    string `yaml:"org"`
+	Bucket string `yaml:"bucket"`
+
+	// Token is the API token, it can be overridden with the
+	// TASMOTA_EXPORTER_INFLUX_TOKEN environment variable.
+	Token string `yaml:"token"`
+
+	// Interval is how often the targets are written. Defaults to
+	// defaultInfluxPushInterval.
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is how long a write may take. Defaults to
+	// defaultInfluxPushTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+const (
+	defaultInfluxMeasurement  = "tasmota"
+	defaultInfluxTargetTag    = "target"
+	defaultInfluxPushInterval = time.Minute
+	defaultInfluxPushTimeout  = 10 * time.Second
+)
+
+// influxValues are the values of a plug which can be written as fields.
+var influxValues = map[string]func(TasmotaPlug) any{
+	"on":             func(tp TasmotaPlug) any { return tp.On },
+	"voltage":        func(tp TasmotaPlug) any { return tp.Voltage },
+	"current":        func(tp TasmotaPlug) any { return tp.Current },
+	"power":          func(tp TasmotaPlug) any { return tp.Power },
+	"apparent_power": func(tp TasmotaPlug) any { return tp.ApparentPower },
+	"reactive_power": func(tp TasmotaPlug) any { return tp.ReactivePower },
+	"factor":         func(tp TasmotaPlug) any { return tp.Factor },
+	"today":          func(tp TasmotaPlug) any { return tp.Today },
+	"yesterday":      func(tp TasmotaPlug) any { return tp.Yesterday },
+	"total":          func(tp TasmotaPlug) any { return tp.Total },
+	"export":         func(tp TasmotaPlug) any { return tp.Export },
+	"frequency":      func(tp TasmotaPlug) any { return tp.Frequency },
+}
+
+func (c *InfluxConfig) validate(cfg *Config) error {
+	for key := range c.Tags {
+		if key == "" {
+			return errors.New("tag names must not be empty")
+		}
+	}
+
+	for field, value := range c.Fields {
+		if field == "" {
+			return errors.New("field names must not be empty")
+		}
+		if _, ok := influxValues[value]; !ok {
+			return fmt.Errorf("field %s: unknown value %q", field, value)
+		}
+	}
+
+	if c.Push != nil {
+		if err := c.Push.validate(); err != nil {
+			return fmt.Errorf("push: %w", err)
+		}
+		if len(cfg.Targets) == 0 {
+			return errors.New("push needs targets in the configuration file")
+		}
+	}
+
+	return nil
+}
+
+func (p *InfluxPushConfig) validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	if p.Org == "" || p.Bucket == "" {
+		return errors.New("org and bucket must be set")
+	}
+	if p.Interval < 0 || p.Timeout < 0 {
+		return errors.New("interval and timeout must be positive")
+	}
+
+	return nil
+}
+
+// influx returns the settings of the line protocol output with their
+// defaults filled in.
+func (c *Config) influx() InfluxConfig {
+	var ic InfluxConfig
+	if c.Influx != nil {
+		ic = *c.Influx
+	}
+
+	if ic.Measurement == "" {
+		ic.Measurement = defaultInfluxMeasurement
+	}
+	if ic.TargetTag == "" {
+		ic.TargetTag = defaultInfluxTargetTag
+	}
+	if len(ic.Fields) == 0 {
+		ic.Fields = make(map[string]string, len(influxValues))
+		for name := range influxValues {
+			ic.Fields[name] = name
+		}
+	}
+
+	return ic
+}
+
+// appendLine appends the line of a successful probe to b, with tags and
+// fields sorted by name.
+func (c *InfluxConfig) appendLine(b []byte, res probeResult, ts time.Time) []byte {
+	b = append(b, influxEscape(c.Measurement, ", ")...)
+
+	tags := maps.Clone(c.Tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags[c.TargetTag] = res.target
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		// Empty tag values are not allowed by the line protocol.
+		if tags[key] == "" {
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, influxEscape(key, ",= ")...)
+		b = append(b, '=')
+		b = append(b, influxEscape(tags[key], ",= ")...)
+	}
+
+	for i, field := range slices.Sorted(maps.Keys(c.Fields)) {
+		if i == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, influxEscape(field, ",= ")...)
+		b = append(b, '=')
+
+		switch v := influxValues[c.Fields[field]](res.plug).(type) {
+		case bool:
+			b = strconv.AppendBool(b, v)
+		case float64:
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		}
+	}
+
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts.UnixNano(), 10)
+
+	return append(b, '\n')
+}
+
+// influxEscape escapes the characters of s with a special meaning in the
+// line protocol.
+func influxEscape(s, special string) string {
+	if !strings.ContainsAny(s, special+`\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for _, r := range s {
+		if r == '\\' || strings.ContainsRune(special, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// influxLines renders the successful results as line protocol.
+func influxLines(results []probeResult) []byte {
+	ic := config.influx()
+
+	var b []byte
+	for _, res := range results {
+		if res.err != nil {
+			continue
+		}
+
+		// Results of the poller are stamped with the time they were
+		// fetched, not the time they are served.
+		ts := res.polledAt
+		if ts.IsZero() {
+			ts = getNow()
+		}
+		b = ic.appendLine(b, res, ts)
+	}
+
+	return b
+}
+
+// influxHandler probes the targets given as parameters like /probe, and
+// returns the plugs as InfluxDB line protocol. Failed probes are left
+// out, the request fails if all of them failed.
+func influxHandler(w http.ResponseWriter, r *http.Request) {
+	targets, module, ok := probeParams(w, r)
+	if !ok {
+		return
+	}
+
+	probeTargets := make([]TargetConfig, 0, len(targets))
+	for _, target := range targets {
+		probeTargets = append(probeTargets, TargetConfig{Address: target, Module: module})
+	}
+	results := probeAll(r.Context(), probeTargets, config.maxConcurrentProbes())
+
+	b := influxLines(results)
+	if len(b) == 0 {
+		http.Error(w, fmt.Sprintf("Probe failed: %s", results[0].err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(b)
+}
+
+var influxPushesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tasmota_exporter_influx_pushes_total",
+	Help: "number of writes of the configured tasmota plugs to InfluxDB, by result",
+}, []string{"result"})
+
+func init() {
+	exporterRegistry.MustRegister(influxPushesCounter)
+}
+
+// influxPusher writes the configured targets to InfluxDB periodically.
+type influxPusher struct {
+	cfg     InfluxPushConfig
+	targets []TargetConfig
+	client  *http.Client
+}
+
+func newInfluxPusher(cfg InfluxPushConfig, targets []TargetConfig) *influxPusher {
+	if overrideInfluxToken != "" {
+		cfg.Token = overrideInfluxToken
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInfluxPushInterval
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultInfluxPushTimeout
+	}
+
+	return &influxPusher{
+		cfg:     cfg,
+		targets: targets,
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// run writes the targets every interval until ctx is done.
+func (p *influxPusher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.push(ctx); err != nil {
+			influxPushesCounter.WithLabelValues("failure").Inc()
+			slog.Error("failed to write to influxdb", "error", err)
+			continue
+		}
+		influxPushesCounter.WithLabelValues("success").Inc()
+	}
+}
+
+// push probes the targets and writes the successful results.
+func (p *influxPusher) push(ctx context.Context) error {
+	results := probeAll(ctx, p.targets, config.maxConcurrentProbes())
+	body := influxLines(results)
+	if len(body) == 0 {
+		return errors.New("all probes failed")
+	}
+
+	u, err := url.JoinPath(p.cfg.URL, "/api/v2/write")
+	if err != nil {
+		return err
+	}
+	u += "?" + url.Values{"org": {p.cfg.Org}, "bucket": {p.cfg.Bucket}, "precision": {"ns"}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+p.cfg.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("influxdb returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
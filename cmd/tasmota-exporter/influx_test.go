@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInfluxAppendLine(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 20, 0, 0, 0, time.UTC)
+	res := probeResult{
+		target: "10.0.0.3:80",
+		plug:   TasmotaPlug{On: true, Voltage: 230.5, Power: 7, Total: 12.25},
+	}
+
+	tests := []struct {
+		name string
+		cfg  *Config
+		want string
+	}{
+		{
+			name: "defaults",
+			cfg:  &Config{},
+			want: "tasmota,target=10.0.0.3:80 apparent_power=0,current=0,export=0,factor=0,frequency=0,on=true,power=7,reactive_power=0,today=0,total=12.25,voltage=230.5,yesterday=0 1705348800000000000\n",
+		},
+		{
+			name: "mapping",
+			cfg: &Config{Influx: &InfluxConfig{
+				Measurement: "energy usage",
+				TargetTag:   "plug",
+				Tags:        map[string]string{"room": "living room", "site": "a=b,c", "empty": ""},
+				Fields:      map[string]string{"watts": "power", "relay on": "on", "kwh": "total"},
+			}},
+			want: `energy\ usage,plug=10.0.0.3:80,room=living\ room,site=a\=b\,c kwh=12.25,relay\ on=true,watts=7 1705348800000000000` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ic := tt.cfg.influx()
+			if got := string(ic.appendLine(nil, res, ts)); got != tt.want {
+				t.Errorf("line = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfluxHandler(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = &Config{Influx: &InfluxConfig{Fields: map[string]string{"voltage": "voltage"}}}
+
+	originalNowFunc := getNow
+	defer func() { getNow = originalNowFunc }()
+	getNow = func() time.Time { return time.Unix(1705348800, 0) }
+
+	target := newFakePlug(t)
+
+	// Nothing is listening on a closed server.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	unreachable := strings.TrimPrefix(closed.URL, "http://")
+	closed.Close()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/influx?target="+target+"&target="+unreachable, nil)
+	influxHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	want := "tasmota,target=" + target + " voltage=237 1705348800000000000\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/influx?target="+unreachable, nil)
+	influxHandler(rec, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+
+	// Repeated targets are probed and written once.
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/influx?target="+target+"&target="+target, nil)
+	influxHandler(rec, req)
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+
+	// The legacy source parameter selects the module like on /probe,
+	// the fake plug only serves the web UI so the status module fails.
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/influx?target="+target+"&source=status", nil)
+	influxHandler(rec, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status with source=status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/influx?target="+target+"&source=unknown", nil)
+	influxHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status with an unknown source = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestInfluxPush(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	originalNowFunc := getNow
+	defer func() { getNow = originalNowFunc }()
+	getNow = func() time.Time { return time.Unix(1705348800, 0) }
+
+	target := newFakePlug(t)
+
+	var gotQuery, gotAuth, gotBody string
+	influxdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotQuery, gotAuth, gotBody = r.URL.RawQuery, r.Header.Get("Authorization"), string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influxdb.Close()
+
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = &Config{
+		Targets: []TargetConfig{{Address: target}},
+		Influx: &InfluxConfig{
+			Fields: map[string]string{"voltage": "voltage"},
+			Push:   &InfluxPushConfig{URL: influxdb.URL, Org: "home", Bucket: "energy", Token: "secret"},
+		},
+	}
+	if err := config.validate(); err != nil {
+		t.Fatalf("validating config: %s", err)
+	}
+
+	p := newInfluxPusher(*config.Influx.Push, config.Targets)
+	if err := p.push(context.Background()); err != nil {
+		t.Fatalf("push() error = %s", err)
+	}
+
+	got := []string{gotQuery, gotAuth, gotBody}
+	want := []string{
+		"bucket=energy&org=home&precision=ns",
+		"Token secret",
+		"tasmota,target=" + target + " voltage=237 1705348800000000000\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected write (-want +got):\n%s", diff)
+	}
+
+	// Errors of InfluxDB are reported.
+	p.cfg.URL = influxdb.URL + "/missing"
+	if err := p.push(context.Background()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("push() error = %v, want the status of InfluxDB", err)
+	}
+}
+
+func TestInfluxValidate(t *testing.T) {
+	push := &InfluxPushConfig{URL: "http://influxdb:8086", Org: "home", Bucket: "energy"}
+
+	for name, cfg := range map[string]*Config{
+		"unknown value":       {Influx: &InfluxConfig{Fields: map[string]string{"temp": "temperature"}}},
+		"empty field name":    {Influx: &InfluxConfig{Fields: map[string]string{"": "power"}}},
+		"empty tag name":      {Influx: &InfluxConfig{Tags: map[string]string{"": "x"}}},
+		"push without target": {Influx: &InfluxConfig{Push: push}},
+		"push without bucket": {Targets: []TargetConfig{{Address: "10.0.0.3"}}, Influx: &InfluxConfig{Push: &InfluxPushConfig{URL: push.URL, Org: "home"}}},
+		"push invalid url":    {Targets: []TargetConfig{{Address: "10.0.0.3"}}, Influx: &InfluxConfig{Push: &InfluxPushConfig{URL: "influxdb:8086", Org: "home", Bucket: "energy"}}},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DeviceLimitsConfig limits the requests the exporter makes to a single
+// plug. The web server of Tasmota is single threaded, too many requests
+// at once make it slow or even reboot.
+type DeviceLimitsConfig struct {
+	// MaxConcurrentRequests is how many requests can be in flight to
+	// a plug at once. Defaults to 1.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+
+	// RequestsPerSecond is the sustained rate of requests to a plug,
+	// 0 disables rate limiting.
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+
+	// Burst is how many requests can be made at once before the rate
+	// limit applies. Defaults to 1.
+	Burst int `yaml:"burst"`
+}
+
+func (d DeviceLimitsConfig) validate() error {
+	if d.MaxConcurrentRequests < 0 {
+		return errors.New("max_concurrent_requests must be positive")
+	}
+	if d.RequestsPerSecond < 0 {
+		return errors.New("requests_per_second must be positive")
+	}
+	if d.Burst < 0 {
+		return errors.New("burst must be positive")
+	}
+
+	return nil
+}
+
+var throttledProbesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tasmota_exporter_probe_throttled_total",
+	Help: "number of requests to a tasmota plug which had to wait for, or were rejected by, the per device limits",
+}, []string{"target"})
+
+func init() {
+	exporterRegistry.MustRegister(throttledProbesCounter)
+}
+
+// tokenBucket is a rate limiter allowing rate requests per second on
+// average, with bursts of up to burst requests.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait
+// before using it. If the wait would end after the deadline of ctx, no
+// token is taken and an error is returned.
+func (b *tokenBucket) reserve(ctx context.Context) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+		return 0, errors.New("rate limit would be exceeded before the probe deadline")
+	}
+
+	b.tokens--
+
+	return wait, nil
+}
+
+// deviceLimiter limits the requests to a single plug.
+type deviceLimiter struct {
+	sem    chan struct{}
+	bucket *tokenBucket
+}
+
+// acquire waits until a request can be made to the plug, or ctx is
+// done, and reports if the request was delayed by the limits. The
+// returned function must be called when the request is done.
+func (l *deviceLimiter) acquire(ctx context.Context) (func(), bool, error) {
+	throttled := false
+
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		throttled = true
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, true, fmt.Errorf("waiting for other requests to the plug: %w", ctx.Err())
+		}
+	}
+	release := func() { <-l.sem }
+
+	if l.bucket != nil {
+		wait, err := l.bucket.reserve(ctx)
+		if err != nil {
+			release()
+			return nil, true, err
+		}
+
+		if wait > 0 {
+			throttled = true
+
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				release()
+				return nil, true, fmt.Errorf("waiting for rate limit: %w", ctx.Err())
+			}
+		}
+	}
+
+	return release, throttled, nil
+}
+
+// deviceLimiters holds the limiter of every plug requests have been
+// made to.
+type deviceLimiters struct {
+	cfg DeviceLimitsConfig
+
+	mu       sync.Mutex
+	limiters map[string]*deviceLimiter
+}
+
+var limiters = newDeviceLimiters(DeviceLimitsConfig{})
+
+func newDeviceLimiters(cfg DeviceLimitsConfig) *deviceLimiters {
+	return &deviceLimiters{
+		cfg:      cfg,
+		limiters: make(map[string]*deviceLimiter),
+	}
+}
+
+func (d *deviceLimiters) get(target string) *deviceLimiter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if l, ok := d.limiters[target]; ok {
+		return l
+	}
+
+	concurrency := d.cfg.MaxConcurrentRequests
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	l := &deviceLimiter{sem: make(chan struct{}, concurrency)}
+	if d.cfg.RequestsPerSecond > 0 {
+		burst := d.cfg.Burst
+		if burst == 0 {
+			burst = 1
+		}
+		l.bucket = newTokenBucket(d.cfg.RequestsPerSecond, burst)
+	}
+	d.limiters[target] = l
+
+	return l
+}
+
+// acquire waits until a request can be made to target, counting the
+// requests which were delayed or rejected. The returned function must be
+// called when the request is done.
+func (d *deviceLimiters) acquire(ctx context.Context, target string) (func(), error) {
+	release, throttled, err := d.get(target).acquire(ctx)
+	if throttled {
+		throttledProbesCounter.WithLabelValues(target).Inc()
+	}
+
+	return release, err
+}
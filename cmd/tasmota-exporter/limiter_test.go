@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeviceLimiterConcurrency(t *testing.T) {
+	d := newDeviceLimiters(DeviceLimitsConfig{})
+	target := "limiter-concurrency"
+	before := promtest.ToFloat64(throttledProbesCounter.WithLabelValues(target))
+
+	release, err := d.acquire(context.Background(), target)
+	if err != nil {
+		t.Fatalf("acquire() error = %s", err)
+	}
+
+	// A second request waits for the first one, and gives up when its
+	// deadline is reached.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := d.acquire(ctx, target); err == nil {
+		t.Errorf("expected acquire() to fail while another request is in flight")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := d.acquire(context.Background(), target)
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	release()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("queued request was not let through when the first one was done")
+	}
+
+	after := promtest.ToFloat64(throttledProbesCounter.WithLabelValues(target))
+	if got := after - before; got != 2 {
+		t.Errorf("throttled requests = %v, want 2", got)
+	}
+}
+
+func TestDeviceLimiterRate(t *testing.T) {
+	d := newDeviceLimiters(DeviceLimitsConfig{MaxConcurrentRequests: 10, RequestsPerSecond: 20, Burst: 2})
+	target := "limiter-rate"
+
+	start := time.Now()
+	for range 4 {
+		release, err := d.acquire(context.Background(), target)
+		if err != nil {
+			t.Fatalf("acquire() error = %s", err)
+		}
+		release()
+	}
+
+	// Two requests are let through by the burst, the next two have to
+	// wait 50ms each.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("4 requests took %s, expected the rate limit to delay them", elapsed)
+	}
+
+	// A request which would have to wait past its deadline is rejected
+	// right away.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := d.acquire(ctx, target); err == nil {
+		t.Errorf("expected acquire() to fail when the rate limit exceeds the deadline")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("creating logger: %s", err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown", "target", "plug")
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected a single json line, got %q: %s", buf.String(), err)
+	}
+	if got["msg"] != "shown" || got["target"] != "plug" {
+		t.Errorf("unexpected log line: %q", buf.String())
+	}
+
+	buf.Reset()
+	logger, err = newLogger(&buf, "DEBUG", "logfmt")
+	if err != nil {
+		t.Fatalf("creating logger: %s", err)
+	}
+	logger.Debug("shown", "target", "plug")
+	if !strings.Contains(buf.String(), `level=DEBUG msg=shown target=plug`) {
+		t.Errorf("unexpected log line: %q", buf.String())
+	}
+
+	if _, err := newLogger(&buf, "verbose", "logfmt"); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+	if _, err := newLogger(&buf, "info", "xml"); err == nil {
+		t.Error("expected an error for an invalid format")
+	}
+}
+
+func TestTeeHandler(t *testing.T) {
+	var info, debug bytes.Buffer
+	logger := slog.New(teeHandler{
+		slog.NewTextHandler(&info, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		slog.NewTextHandler(&debug, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	}).With("target", "plug")
+
+	ctx := withLogger(context.Background(), logger)
+	loggerFrom(ctx).Debug("debug only")
+	loggerFrom(ctx).Info("both")
+
+	if strings.Contains(info.String(), "debug only") {
+		t.Errorf("debug record written to info handler: %q", info.String())
+	}
+	if !strings.Contains(info.String(), "msg=both target=plug") {
+		t.Errorf("info record missing from info handler: %q", info.String())
+	}
+	if !strings.Contains(debug.String(), `msg="debug only" target=plug`) || !strings.Contains(debug.String(), "msg=both") {
+		t.Errorf("records missing from debug handler: %q", debug.String())
+	}
+
+	if loggerFrom(context.Background()) != slog.Default() {
+		t.Error("expected the default logger without a logger in the context")
+	}
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
-	"errors"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
-	"log"
-	"math"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -20,146 +25,373 @@ import (
 var overrideListenAddr = envknob.String("TASMOTA_EXPORTER_LISTEN_ADDR")
 
 var (
-	onGauge,
-	voltageGauge,
-	currentGauge,
-	powerGauge,
-	apparentPowerGauge,
-	reactivePowerGauge,
-	factorGauge,
-	todayGauge,
-	yesterdayGauge,
-	totalGauge,
-	dailyLastGauge prometheus.Gauge
-
-	registry *prometheus.Registry
-
-	// Track the last day we sent the daily last metric per target
-	lastDailyMetricSent map[string]time.Time
+	config *Config
+	state  *stateStore
+
+	// exporterRegistry holds metrics about the exporter itself, as
+	// opposed to the plugs, they are served on /metrics.
+	exporterRegistry = prometheus.NewRegistry()
+	metricsHandler   = promhttp.HandlerFor(exporterRegistry, promhttp.HandlerOpts{Registry: exporterRegistry})
 
 	getNow = time.Now
 )
 
 func init() {
-	onGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_on",
-		Help: "Indicates if the tasmota plug is on/off",
-	})
-	voltageGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_voltage_volts",
-		Help: "voltage of tasmota plug in volt (V)",
-	})
-	currentGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_current_amperes",
-		Help: "current of tasmota plug in ampere (A)",
-	})
-	powerGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_power_watts",
-		Help: "current power of tasmota plug in watts (W)",
-	})
-	apparentPowerGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_apparent_power_voltamperes",
-		Help: "apparent power of tasmota plug in volt-amperes (VA)",
-	})
-	reactivePowerGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_reactive_power_voltamperesreactive",
-		Help: "reactive power of tasmota plug in volt-amperes reactive (VAr)",
-	})
-	factorGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_power_factor",
-		Help: "current power factor of tasmota plug",
-	})
-	todayGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_today_kwh_total",
-		Help: "todays energy usage total in kilowatts hours (kWh) [manually overriden to 0 between 23:59:00 and 00:00:59]",
-	})
-	yesterdayGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_yesterday_kwh_total",
-		Help: "yesterdays energy usage total in kilowatts hours (kWh)",
-	})
-	totalGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_kwh_total",
-		Help: "total energy usage in kilowatts hours (kWh)",
-	})
-	dailyLastGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "tasmota_daily_last_kwh_total",
-		Help: "The last kWh reading of the day, sent once per day between 23:58:00 and 23:59:59",
-	})
-
-	registry = prometheus.NewRegistry()
-	registry.MustRegister(onGauge)
-	registry.MustRegister(voltageGauge)
-	registry.MustRegister(currentGauge)
-	registry.MustRegister(powerGauge)
-	registry.MustRegister(apparentPowerGauge)
-	registry.MustRegister(reactivePowerGauge)
-	registry.MustRegister(factorGauge)
-	registry.MustRegister(todayGauge)
-	registry.MustRegister(yesterdayGauge)
-	registry.MustRegister(totalGauge)
-	registry.MustRegister(dailyLastGauge)
-
-	// Initialize the map to track daily metrics per target
-	lastDailyMetricSent = make(map[string]time.Time)
+	config = &Config{}
+	state = &stateStore{Targets: make(map[string]*targetState)}
 }
 
+var (
+	logLevel  = flag.String("log.level", "info", "Only log messages with the given severity or above. One of: [debug, info, warn, error]")
+	logFormat = flag.String("log.format", "logfmt", "Output format of log messages. One of: [logfmt, json]")
+
+	webConfigFile = flag.String("web.config.file", "", "Path to a configuration file that can enable TLS or authentication.")
+)
+
 func main() {
-	// Note: Go's log package uses UTC by default
+	flag.Parse()
+
 	// I have added tzdata in the docker image, so we can use TZ environment variable to see local time in logs
-	// or use a custom logging solution
-	log.SetFlags(log.LstdFlags)
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	config, err = loadConfig(overrideConfigFile)
+	if err != nil {
+		slog.Error("error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	webConfig, err := loadWebConfig(*webConfigFile)
+	if err != nil {
+		slog.Error("error loading web config", "error", err)
+		os.Exit(1)
+	}
+
+	tlsConfig, err := webConfig.tlsConfig()
+	if err != nil {
+		slog.Error("error loading web config", "error", err)
+		os.Exit(1)
+	}
+
+	state, err = loadState(config.StateFile)
+	if err != nil {
+		slog.Error("error loading state", "error", err)
+		os.Exit(1)
+	}
 
-	http.HandleFunc("/probe", tasmotaHandler)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		state.run(ctx, stateFlushInterval)
+	}()
+
+	limiters = newDeviceLimiters(config.DeviceLimits)
+	breakers = newCircuitBreakers(config.CircuitBreaker)
+	deviceClients = newDeviceClients(config.HTTPClient)
+
+	if len(config.Rules) > 0 {
+		activeRules = newRuleEngine(config.Rules)
+	}
+
+	// The webhooks outlive the context, they are stopped once the
+	// polls in flight have published their events.
+	webhooksCtx, cancelWebhooks := context.WithCancel(context.Background())
+	defer cancelWebhooks()
+	var webhooksWG sync.WaitGroup
+	if config.Events != nil && len(config.Events.Webhooks) > 0 {
+		events = newEventBus(config.Events)
+		for _, wh := range config.Events.Webhooks {
+			sink, ch := newWebhookSink(wh), events.subscribe(wh.Name)
+			webhooksWG.Add(1)
+			go func() {
+				defer webhooksWG.Done()
+				sink.run(webhooksCtx, ch)
+			}()
+		}
+	}
+
+	if config.Poll != nil {
+		activePoller = newPoller(config.Poll, config.Targets)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			activePoller.run(ctx)
+		}()
+	}
+
+	if config.Influx != nil && config.Influx.Push != nil {
+		pusher := newInfluxPusher(*config.Influx.Push, config.Targets)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pusher.run(ctx)
+		}()
+	}
 
 	listenAddr := ":9090"
 	if overrideListenAddr != "" {
 		listenAddr = overrideListenAddr
 	}
 
-	log.Printf("starting tasmota exporter on %s", listenAddr)
-	err := http.ListenAndServe(listenAddr, nil)
-	if errors.Is(err, http.ErrServerClosed) {
-		log.Printf("server closed")
-	} else if err != nil {
-		log.Fatalf("error starting server: %s", err)
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		slog.Error("error starting server", "error", err)
+		os.Exit(1)
 	}
+
+	listeners := []net.Listener{ln}
+
+	if tsConfig := config.tailscale(); tsConfig != nil {
+		tsServer, tsListener, err := listenTailscale(tsConfig)
+		if err != nil {
+			slog.Error("error starting tailscale", "error", err)
+			os.Exit(1)
+		}
+		defer tsServer.Close()
+
+		slog.Info("listening on tailnet", "hostname", tsConfig.Hostname, "address", tsConfig.ListenAddr)
+		listeners = append(listeners, tsListener)
+	}
+
+	if tlsConfig != nil {
+		for i, l := range listeners {
+			listeners[i] = tls.NewListener(l, tlsConfig)
+		}
+	}
+
+	slog.Info("starting tasmota exporter", "address", ln.Addr(), "tls", tlsConfig != nil)
+	if err := serve(ctx, newServer(newHandler(webConfig)), listeners...); err != nil {
+		slog.Error("error serving", "error", err)
+	}
+
+	// Wait for the polls in flight, so their results are part of the
+	// last flush of the state.
+	stop()
+	wg.Wait()
+
+	// Give the webhooks some time to post the events left.
+	if events != nil {
+		events.close()
+		timer := time.AfterFunc(shutdownTimeout, cancelWebhooks)
+		webhooksWG.Wait()
+		timer.Stop()
+	}
+
+	if err := state.save(); err != nil {
+		slog.Error("failed to save state", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("server closed")
 }
 
-func tasmotaHandler(w http.ResponseWriter, r *http.Request) {
-	probeSuccessGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_success",
-		Help: "Displays whether or not the probe was a success",
-	})
-	probeDurationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "probe_duration_seconds",
-		Help: "Returns how long the probe took to complete in seconds",
-	})
+// probeTimeout is how long a single probe of a plug may take.
+const probeTimeout = 5 * time.Second
 
+// probeParams returns the targets and the module to probe them with
+// from the parameters of r, for the endpoints probing targets on
+// request. If they are invalid, it writes the error and returns false.
+func probeParams(w http.ResponseWriter, r *http.Request) ([]string, string, bool) {
 	params := r.URL.Query()
 
-	target := params.Get("target")
-	if target == "" {
+	targets := uniqueTargets(params["target"])
+	if len(targets) == 0 || targets[0] == "" {
 		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	// source is the name of the parameter before modules were added.
+	module := params.Get("module")
+	if module == "" {
+		module = params.Get("source")
+	}
+	if _, err := config.module(module); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	if !checkTargets(w, targets) {
+		return nil, "", false
+	}
+
+	return targets, module, true
+}
+
+func tasmotaHandler(w http.ResponseWriter, r *http.Request) {
+	targets, module, ok := probeParams(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("debug") == "true" {
+		if len(targets) > 1 {
+			http.Error(w, "Debug output is only available for a single target", http.StatusBadRequest)
+			return
+		}
+
+		serveProbeDebug(w, r, TargetConfig{Address: targets[0], Module: module})
+		return
+	}
+
+	// A single target keeps the blackbox_exporter style output, where
+	// the target is added as a label by Prometheus relabeling.
+	if len(targets) == 1 {
+		res := probeTarget(r.Context(), TargetConfig{Address: targets[0], Module: module})
+
+		reg := prometheus.NewRegistry()
+		registerProbeMetrics(reg, res, nil)
+
+		h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	probeTargets := make([]TargetConfig, 0, len(targets))
+	for _, target := range targets {
+		probeTargets = append(probeTargets, TargetConfig{Address: target, Module: module})
+	}
+
+	serveMultiProbe(w, r, probeTargets)
+}
+
+// uniqueTargets returns targets without the repeated ones, in the order
+// they were first given. Every target is probed once and would
+// otherwise register its metrics twice.
+func uniqueTargets(targets []string) []string {
+	seen := make(map[string]bool, len(targets))
+	ret := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if !seen[target] {
+			seen[target] = true
+			ret = append(ret, target)
+		}
+	}
+
+	return ret
+}
+
+// allTargetsHandler probes all the targets in the configuration file
+// and returns them in one exposition, labeled by target.
+func allTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	if len(config.Targets) == 0 {
+		http.Error(w, "No targets configured", http.StatusNotFound)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	serveMultiProbe(w, r, config.Targets)
+}
+
+func serveMultiProbe(w http.ResponseWriter, r *http.Request, targets []TargetConfig) {
+	results := probeAll(r.Context(), targets, config.maxConcurrentProbes())
+
+	reg := prometheus.NewRegistry()
+	for _, res := range results {
+		registerProbeMetrics(reg, res, prometheus.Labels{"target": res.target})
+	}
+
+	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, r)
+}
+
+// probeResult is the outcome of probing a target.
+type probeResult struct {
+	target   string
+	module   string
+	plug     TasmotaPlug
+	state    targetState
+	duration time.Duration
+	err      error
+
+	// tls is the state of the connection to the plug, nil if it is
+	// not served over HTTPS.
+	tls *tls.ConnectionState
+
+	// polledAt is when the result was fetched by the background
+	// poller, it is zero for results probed on request.
+	polledAt time.Time
+}
+
+// runProbe probes a target, records the result in the state and logs
+// the outcome.
+func runProbe(ctx context.Context, target TargetConfig) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
 	defer cancel()
-	r = r.WithContext(ctx)
+
+	res := probeResult{target: target.Address, module: target.module()}
+
+	logger := loggerFrom(ctx).With("target", target.Address, "module", res.module)
+	ctx = withLogger(ctx, logger)
+	logger.Debug("beginning probe")
 
 	start := time.Now()
-	success := probeTasmota(target)
-	duration := time.Since(start).Seconds()
-	probeDurationGauge.Set(duration)
-	if success {
-		probeSuccessGauge.Set(1)
-		log.Printf("%s: probe succeeded, duration: %fs", target, duration)
-	} else {
-		log.Printf("%s: probe failed, duration: %fs", target, duration)
+	module, err := config.module(target.Module)
+	if err == nil {
+		res.plug, res.tls, err = probeTasmota(ctx, target.Address, module)
 	}
+	res.err = err
+	res.duration = time.Since(start)
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	observeProbe(res)
+	history.add(res)
+
+	if res.err != nil {
+		logger.Warn("probe failed", "duration", res.duration, "error", res.err)
+		events.observe(res)
+		return res
+	}
+
+	res.state = recordProbe(target.Address, res.plug)
+	logger.Info("probe succeeded", "duration", res.duration)
+	events.observe(res)
+
+	return res
+}
+
+// probeAll probes targets in parallel, with at most concurrency probes
+// in flight at once. The results are in the same order as targets.
+func probeAll(ctx context.Context, targets []TargetConfig, concurrency int) []probeResult {
+	results := make([]probeResult, len(targets))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = probeTarget(ctx, target)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// recordProbe updates the state of target with a successful probe and
+// returns a copy of the updated state.
+func recordProbe(target string, tp TasmotaPlug) targetState {
+	now := getNow().In(config.Location())
+
+	recordEnergy(config.Tariff, target, tp.Total, now)
+	recordDailyTotal(target, tp, now)
+	if t, ok := config.target(target); ok && t.Appliance != nil {
+		recordAppliance(t.Appliance, target, tp, now)
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return *state.Targets[target]
 }
 
 // isMidnightTransition checks if we're in the window around midnight (23:59:00 to 00:00:59).
@@ -170,117 +402,55 @@ func tasmotaHandler(w http.ResponseWriter, r *http.Request) {
 func isMidnightTransition(now time.Time) bool {
 	hour := now.Hour()
 	minute := now.Minute()
-	// second := now.Second()
-
-	// log.Printf("isMidnightTransition: checking time: %s (h: %d, m: %d, s: %d)", now.Format(time.RFC3339), hour, minute, second)
 
 	// Check if time is between 23:59:00 and 00:00:59
 	// time.Hour() method in Go's standard library always returns in 24-hour format (0-23),
 	// independent of the system's locale or time format settings.
 	// This is why we can use 23 and 0 for the hour check.
 	if hour == 23 && minute == 59 {
-		log.Println("isMidnightTransition: returning true (23:59 case)")
+		slog.Debug("in midnight transition", "case", "23:59")
 		return true
 	}
 	if hour == 0 && minute == 0 {
-		log.Println("isMidnightTransition: returning true (00:00 case)")
+		slog.Debug("in midnight transition", "case", "00:00")
 		return true
 	}
 	return false
 }
 
-// isDailyMetricWindow checks if we're in the window to send the daily last metric (23:58:00 to 23:59:59)
-func isDailyMetricWindow(t time.Time) bool {
-	hour := t.Hour()
-	minute := t.Minute()
-	return hour == 23 && (minute == 58 || minute == 59)
-	// return true
-}
-
-// shouldSendDailyMetric checks if we should send the daily metric
-// Returns true if we're in the time window and haven't sent it today
-func shouldSendDailyMetric(target string) bool {
-	n := getNow()
+func probeTasmota(ctx context.Context, target string, module ModuleConfig) (TasmotaPlug, *tls.ConnectionState, error) {
+	source := module.source()
 
-	// If we're not in the time window, don't send
-	if !isDailyMetricWindow(n) {
-		return false
+	path := "?m"
+	if source == sourceStatus {
+		path = "/cm?cmnd=Status%200"
 	}
 
-	log.Printf("[%s] We may have to send the last daily metric", target)
-
-	// If we haven't sent it today, we should send it
-	if lastDailyMetricSent[target].Day() != n.Day() ||
-		lastDailyMetricSent[target].Month() != n.Month() ||
-		lastDailyMetricSent[target].Year() != n.Year() {
-		log.Printf("[%s] We didn't send the last daily metric yet, so we should send it", target)
-		return true
-	}
-
-	log.Printf("[%s] We already sent the last daily metric at %s, so we don't need to send it again", target, lastDailyMetricSent[target])
-
-	return false
-}
-
-func probeTasmota(target string) (success bool) {
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
-
-	resp, err := client.Get(fmt.Sprintf("http://%s?m", target))
+	resp, err := fetchDevice(ctx, target, module, path)
 	if err != nil {
-		log.Printf("failed to query tasmota target (%s): %s", target, err)
-		return false
+		return TasmotaPlug{}, nil, err
 	}
+	debugRaw(ctx, resp.body)
+	loggerFrom(ctx).Debug("parsing response", "bytes", len(resp.body), "source", source)
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("failed to read data from tasmota target (%s): %s", target, err)
-		return false
-	}
-
-	tp := parse(string(body))
-
-	if tp.On {
-		onGauge.Set(1)
-	} else {
-		onGauge.Set(0)
-	}
-	voltageGauge.Set(tp.Voltage)
-	currentGauge.Set(tp.Current)
-	powerGauge.Set(tp.Power)
-	apparentPowerGauge.Set(tp.ApparentPower)
-	reactivePowerGauge.Set(tp.ReactivePower)
-	factorGauge.Set(tp.Factor)
-
-	todayGauge.Set(getTodayValue(tp.Today))
-
-	yesterdayGauge.Set(tp.Yesterday)
-	totalGauge.Set(tp.Total)
+	if source == sourceStatus {
+		tp, err := parseStatus(resp.body)
+		if err != nil {
+			return TasmotaPlug{}, resp.tls, parseError{fmt.Errorf("failed to parse status from tasmota target: %w", err)}
+		}
 
-	// Handle daily last metric
-	if shouldSendDailyMetric(target) {
-		dailyLastGauge.Set(tp.Today)
-		lastDailyMetricSent[target] = getNow()
-	} else {
-		// If the metric should not be sent for this target at this time,
-		// we must explicitly set the gauge to NaN. If we don't, the gauge
-		// will continue to expose the last value it was set to, which may
-		// have been for a different target. This would cause all targets
-		// to incorrectly report the same "last daily" value.
-		dailyLastGauge.Set(math.NaN())
+		return tp, resp.tls, nil
 	}
 
-	return true
+	return parse(string(resp.body)), resp.tls, nil
 }
 
 func getTodayValue(tasmotaToday float64) float64 {
 	if isMidnightTransition(getNow()) {
-		log.Printf("Midnight transition detected. Setting todayGauge to 0.")
+		slog.Debug("midnight transition detected, setting today to 0")
 		return 0
 	}
 
-	// log.Printf("Not in midnight transition. Setting todayGauge to %f.", tasmotaToday)
 	return tasmotaToday
 }
 
@@ -296,7 +466,8 @@ type TasmotaPlug struct {
 	// in A.
 	Current float64 `json:"Current"`
 
-	// Power describes the current power used, denoted in W (watt)
+	// Power describes the current power used, denoted in W (watt).
+	// It is negative when the plug is exporting energy.
 	Power float64 `json:"Power"`
 
 	// ApparentPower describes the volt-ampere (VA)
@@ -319,6 +490,25 @@ type TasmotaPlug struct {
 	// Total is the total usage of energy in kilowatts hours (kWh)
 	// since the plug was last factory reset.
 	Total float64 `json:"Total"`
+
+	// Export is the total energy fed back to the grid in kilowatts
+	// hours (kWh), only reported by bidirectional energy drivers.
+	Export float64 `json:"ExportActive"`
+
+	// Frequency describes the grid frequency, denoted in Hz.
+	Frequency float64 `json:"Frequency"`
+
+	// HasFrequency is set when the plug reports Frequency, most
+	// plugs do not measure it.
+	HasFrequency bool `json:"-"`
+
+	// Period is the energy used since the last telemetry
+	// period, denoted in Wh.
+	Period float64 `json:"Period"`
+
+	// TotalStartTime is when the Total counter was last reset.
+	// It is only reported in the JSON status of the plug.
+	TotalStartTime time.Time `json:"TotalStartTime"`
 }
 
 func parse(input string) TasmotaPlug {
@@ -378,11 +568,87 @@ func parse(input string) TasmotaPlug {
 			ret.Yesterday = value
 		case "Energy Total":
 			ret.Total = value
+		case "Energy Export", "Export Active":
+			ret.Export = value
+		case "Frequency":
+			ret.Frequency = value
+			ret.HasFrequency = true
+		case "Period":
+			ret.Period = value
 		default:
-			log.Printf("unable to match label, got: %s, value: %f", label, value)
-
+			slog.Debug("unable to match label", "label", label, "value", value)
 		}
 	}
 
 	return ret
 }
+
+// tasmotaTimeLayout is the layout Tasmota uses for timestamps in its
+// JSON output, they are in the local time of the plug.
+const tasmotaTimeLayout = "2006-01-02T15:04:05"
+
+// tasmotaStatus is the subset of the Status 0 command output
+// we care about.
+type tasmotaStatus struct {
+	StatusSTS struct {
+		Power string `json:"POWER"`
+	} `json:"StatusSTS"`
+	StatusSNS struct {
+		Energy struct {
+			TotalStartTime string   `json:"TotalStartTime"`
+			Total          float64  `json:"Total"`
+			Yesterday      float64  `json:"Yesterday"`
+			Today          float64  `json:"Today"`
+			Period         float64  `json:"Period"`
+			Power          float64  `json:"Power"`
+			ApparentPower  float64  `json:"ApparentPower"`
+			ReactivePower  float64  `json:"ReactivePower"`
+			Factor         float64  `json:"Factor"`
+			Frequency      *float64 `json:"Frequency"`
+			Voltage        float64  `json:"Voltage"`
+			Current        float64  `json:"Current"`
+			ExportActive   float64  `json:"ExportActive"`
+		} `json:"ENERGY"`
+	} `json:"StatusSNS"`
+}
+
+// parseStatus parses the JSON output of the Status 0 command.
+func parseStatus(input []byte) (TasmotaPlug, error) {
+	var status tasmotaStatus
+	if err := json.Unmarshal(input, &status); err != nil {
+		return TasmotaPlug{}, fmt.Errorf("decoding status: %w", err)
+	}
+
+	energy := status.StatusSNS.Energy
+	ret := TasmotaPlug{
+		On:            status.StatusSTS.Power == "ON",
+		Voltage:       energy.Voltage,
+		Current:       energy.Current,
+		Power:         energy.Power,
+		ApparentPower: energy.ApparentPower,
+		ReactivePower: energy.ReactivePower,
+		Factor:        energy.Factor,
+		Today:         energy.Today,
+		Yesterday:     energy.Yesterday,
+		Total:         energy.Total,
+		Export:        energy.ExportActive,
+		Period:        energy.Period,
+	}
+
+	if energy.Frequency != nil {
+		ret.Frequency = *energy.Frequency
+		ret.HasFrequency = true
+	}
+
+	if energy.TotalStartTime != "" {
+		// The plug reports its local time, which is assumed to be in
+		// the timezone of the configuration file.
+		startTime, err := time.ParseInLocation(tasmotaTimeLayout, energy.TotalStartTime, config.Location())
+		if err != nil {
+			return TasmotaPlug{}, fmt.Errorf("parsing TotalStartTime: %w", err)
+		}
+		ret.TotalStartTime = startTime
+	}
+
+	return ret, nil
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
-	"math"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
-	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestParser(t *testing.T) {
@@ -195,6 +199,25 @@ func TestParser(t *testing.T) {
 				Total:         1.121,
 			},
 		},
+		{
+			name: "balcony-solar-exporting",
+			input: `{t}</table><hr/>{t}{s}</th><th></th><th style='text-align:center'><th></th><td>{e}{s}Voltage{m}</td><td style='text-align:left'>235</td><td>&nbsp;</td><td> V{e}{s}Current{m}</td><td style='text-align:left'>1.520</td><td>&nbsp;</td><td> A{e}{s}Active Power{m}</td><td style='text-align:left'>-348</td><td>&nbsp;</td><td> W{e}{s}Apparent Power{m}</td><td style='text-align:left'>357</td><td>&nbsp;</td><td> VA{e}{s}Reactive Power{m}</td><td style='text-align:left'>79</td><td>&nbsp;</td><td> VAr{e}{s}Power Factor{m}</td><td style='text-align:left'>0.97</td><td>&nbsp;</td><td>                         {e}{s}Energy Today{m}</td><td style='text-align:left'>0.021</td><td>&nbsp;</td><td> kWh{e}{s}Energy Yesterday{m}</td><td style='text-align:left'>0.034</td><td>&nbsp;</td><td> kWh{e}{s}Energy Total{m}</td><td style='text-align:left'>12.480</td><td>&nbsp;</td><td> kWh{e}{s}Energy Export{m}</td><td style='text-align:left'>87.215</td><td>&nbsp;</td><td> kWh{e}</table><hr/>{t}</table>{t}<tr><td style='width:100%;text-align:center;font-weight:bold;font-size:62px'>ON</td></tr><tr></tr></table>
+
+			`,
+			want: TasmotaPlug{
+				On:            true,
+				Voltage:       235,
+				Current:       1.52,
+				Power:         -348,
+				ApparentPower: 357,
+				ReactivePower: 79,
+				Factor:        0.97,
+				Today:         0.021,
+				Yesterday:     0.034,
+				Total:         12.48,
+				Export:        87.215,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -208,6 +231,49 @@ func TestParser(t *testing.T) {
 	}
 }
 
+func TestParseStatus(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("loading timezone: %s", err)
+	}
+	config = &Config{location: tokyo}
+
+	input := `{"Status":{"Module":0,"DeviceName":"Balcony","FriendlyName":["Balcony"],"Topic":"balcony","Power":1},"StatusSTS":{"Time":"2024-07-26T12:00:00","Uptime":"3T01:02:03","POWER":"ON"},"StatusSNS":{"Time":"2024-07-26T12:00:00","ENERGY":{"TotalStartTime":"2023-03-14T09:26:53","Total":12.480,"Yesterday":0.034,"Today":0.021,"Period":3,"Power":-348,"ApparentPower":357,"ReactivePower":79,"Factor":0.97,"Frequency":50.02,"Voltage":235,"Current":1.520,"ExportActive":87.215}}}`
+
+	got, err := parseStatus([]byte(input))
+	if err != nil {
+		t.Fatalf("parseStatus() error = %s", err)
+	}
+
+	want := TasmotaPlug{
+		On:             true,
+		Voltage:        235,
+		Current:        1.52,
+		Power:          -348,
+		ApparentPower:  357,
+		ReactivePower:  79,
+		Factor:         0.97,
+		Today:          0.021,
+		Yesterday:      0.034,
+		Total:          12.48,
+		Export:         87.215,
+		Frequency:      50.02,
+		HasFrequency:   true,
+		Period:         3,
+		TotalStartTime: time.Date(2023, 3, 14, 9, 26, 53, 0, tokyo),
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected parsed status (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseStatus([]byte("<html>")); err == nil {
+		t.Errorf("parseStatus() expected error for non JSON input")
+	}
+}
+
 func TestGetTodayValue(t *testing.T) {
 	// We need to be able to control the time for this test
 	originalNowFunc := getNow
@@ -253,147 +319,184 @@ func TestGetTodayValue(t *testing.T) {
 	}
 }
 
-func TestHandleDailyLastMetric(t *testing.T) {
-	// We need to be able to control the time for this test
-	originalNowFunc := getNow
-	defer func() { getNow = originalNowFunc }()
-
-	// We need to reset the state for each test run
-	originalLastDailyMetricSent := lastDailyMetricSent
-	defer func() { lastDailyMetricSent = originalLastDailyMetricSent }()
+func TestTodayValue_MidnightTransitionLogic(t *testing.T) {
+	mockTasmotaData := `{t}</table><hr/>{t}{s}</th><th></th><th style='text-align:center'><th></th><td>{e}{s}Voltage{m}</td><td style='text-align:left'>237</td><td>&nbsp;</td><td> V{e}{s}Current{m}</td><td style='text-align:left'>0.053</td><td>&nbsp;</td><td> A{e}{s}Active Power{m}</td><td style='text-align:left'>7</td><td>&nbsp;</td><td> W{e}{s}Apparent Power{m}</td><td style='text-align:left'>13</td><td>&nbsp;</td><td> VA{e}{s}Reactive Power{m}</td><td style='text-align:left'>10</td><td>&nbsp;</td><td> VAr{e}{s}Power Factor{m}</td><td style='text-align:left'>0.59</td><td>&nbsp;</td><td>                         {e}{s}Energy Today{m}</td><td style='text-align:left'>42.42</td><td>&nbsp;</td><td> kWh{e}{s}Energy Yesterday{m}</td><td style='text-align:left'>0.016</td><td>&nbsp;</td><td> kWh{e}{s}Energy Total{m}</td><td style='text-align:left'>3.334</td><td>&nbsp;</td><td> kWh{e}</table><hr/>{t}</table>{t}<tr><td style='width:100%;text-align:center;font-weight:bold;font-size:62px'>ON</td></tr><tr></tr></table>`
 
-	target := "test-target"
-	mockPlug := TasmotaPlug{Today: 1.234}
+	tp := parse(mockTasmotaData)
 
 	tests := []struct {
-		name             string
-		mockTime         time.Time
-		setupSentMap     func()
-		expectGaugeValue float64
+		name     string
+		time     time.Time
+		expected float64
 	}{
 		{
-			name:             "not in window - should set gauge to NaN",
-			mockTime:         time.Date(2024, 7, 26, 10, 0, 0, 0, time.UTC),
-			expectGaugeValue: math.NaN(),
-		},
-		{
-			name:             "in window, not sent yet - should set gauge to value",
-			mockTime:         time.Date(2024, 7, 26, 23, 58, 0, 0, time.UTC),
-			expectGaugeValue: mockPlug.Today,
+			name:     "outside midnight window",
+			time:     time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+			expected: tp.Today,
 		},
 		{
-			name:     "in window, but already sent today - should set gauge to NaN",
-			mockTime: time.Date(2024, 7, 26, 23, 59, 0, 0, time.UTC),
-			setupSentMap: func() {
-				lastDailyMetricSent[target] = time.Date(2024, 7, 26, 23, 58, 0, 0, time.UTC)
-			},
-			expectGaugeValue: math.NaN(),
+			name:     "inside midnight window (23:59:30)",
+			time:     time.Date(2024, 1, 15, 23, 59, 30, 0, time.UTC),
+			expected: 0,
 		},
 		{
-			name: "next day, in window - should set gauge to value again",
-			setupSentMap: func() {
-				lastDailyMetricSent[target] = time.Date(2024, 7, 26, 23, 58, 0, 0, time.UTC)
-			},
-			mockTime:         time.Date(2024, 7, 27, 23, 58, 0, 0, time.UTC),
-			expectGaugeValue: mockPlug.Today,
+			name:     "inside midnight window (00:00:30)",
+			time:     time.Date(2024, 1, 16, 0, 0, 30, 0, time.UTC),
+			expected: 0,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Reset the state for a clean test run
-			lastDailyMetricSent = make(map[string]time.Time)
-			dailyLastGauge.Set(0) // Reset gauge to a known state
-
-			if tt.setupSentMap != nil {
-				tt.setupSentMap()
-			}
-
-			getNow = func() time.Time { return tt.mockTime }
-
-			// Call the function that contains the logic we are testing
-			handleDailyLastMetric(target, mockPlug)
-
-			// Get the resulting metric value
-			metricValue := promtest.ToFloat64(dailyLastGauge)
-
-			if math.IsNaN(tt.expectGaugeValue) {
-				if !math.IsNaN(metricValue) {
-					t.Errorf("dailyLastGauge value = %v, want NaN", metricValue)
-				}
+			var today float64
+			if isMidnightTransition(tt.time) {
+				today = 0
 			} else {
-				if metricValue != tt.expectGaugeValue {
-					t.Errorf("dailyLastGauge value = %v, want %v", metricValue, tt.expectGaugeValue)
-				}
+				today = tp.Today
+			}
+			if today != tt.expected {
+				t.Errorf("For time %v, expected Today value %v, got %v", tt.time, tt.expected, today)
 			}
 		})
 	}
 }
 
-func TestIsDailyMetricWindow(t *testing.T) {
-	// Time outside the window
-	outside := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC) // 12:00
-	if isDailyMetricWindow(outside) {
-		t.Errorf("Expected isDailyMetricWindow to return false for 12:00, got true")
-	}
+// fakePlugPage is the web UI output of a plug, used by fake plugs.
+const fakePlugPage = `{t}</table><hr/>{t}{s}</th><th></th><th style='text-align:center'><th></th><td>{e}{s}Voltage{m}</td><td style='text-align:left'>237</td><td>&nbsp;</td><td> V{e}{s}Current{m}</td><td style='text-align:left'>0.053</td><td>&nbsp;</td><td> A{e}{s}Active Power{m}</td><td style='text-align:left'>7</td><td>&nbsp;</td><td> W{e}{s}Apparent Power{m}</td><td style='text-align:left'>13</td><td>&nbsp;</td><td> VA{e}{s}Reactive Power{m}</td><td style='text-align:left'>10</td><td>&nbsp;</td><td> VAr{e}{s}Power Factor{m}</td><td style='text-align:left'>0.59</td><td>&nbsp;</td><td>                         {e}{s}Energy Today{m}</td><td style='text-align:left'>0.002</td><td>&nbsp;</td><td> kWh{e}{s}Energy Yesterday{m}</td><td style='text-align:left'>0.016</td><td>&nbsp;</td><td> kWh{e}{s}Energy Total{m}</td><td style='text-align:left'>3.334</td><td>&nbsp;</td><td> kWh{e}</table><hr/>{t}</table>{t}<tr><td style='width:100%;text-align:center;font-weight:bold;font-size:62px'>ON</td></tr><tr></tr></table>`
 
-	// Time inside the window
-	inside := time.Date(2024, 1, 15, 23, 58, 0, 0, time.UTC) // 23:58
-	if !isDailyMetricWindow(inside) {
-		t.Errorf("Expected isDailyMetricWindow to return true for 23:58, got false")
-	}
+// newFakePlug starts a HTTP server serving the web UI of a plug and
+// returns its address, to be used as target.
+func newFakePlug(t *testing.T) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fakePlugPage)
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://")
+}
 
-	// Another time inside the window
-	inside2 := time.Date(2024, 1, 15, 23, 59, 0, 0, time.UTC) // 23:59
-	if !isDailyMetricWindow(inside2) {
-		t.Errorf("Expected isDailyMetricWindow to return true for 23:59, got false")
+func TestProbeMultipleTargets(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	first := newFakePlug(t)
+	second := newFakePlug(t)
+
+	// Nothing is listening on a closed server.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	unreachable := strings.TrimPrefix(closed.URL, "http://")
+	closed.Close()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/probe?target="+first+"&target="+second+"&target="+unreachable, nil)
+	tasmotaHandler(rec, req)
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		fmt.Sprintf(`probe_success{target=%q} 1`, first),
+		fmt.Sprintf(`probe_success{target=%q} 1`, second),
+		fmt.Sprintf(`probe_success{target=%q} 0`, unreachable),
+		fmt.Sprintf(`tasmota_power_watts{target=%q} 7`, first),
+		fmt.Sprintf(`tasmota_power_watts{target=%q} 7`, second),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, body)
+		}
 	}
 
-	// Edge case: 00:00 (should be false)
-	edge := time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC) // 00:00
-	if isDailyMetricWindow(edge) {
-		t.Errorf("Expected isDailyMetricWindow to return false for 00:00, got true")
+	if strings.Contains(body, fmt.Sprintf(`tasmota_power_watts{target=%q}`, unreachable)) {
+		t.Errorf("expected no plug metrics for failed target, got:\n%s", body)
 	}
 }
 
-func TestTodayValue_MidnightTransitionLogic(t *testing.T) {
-	mockTasmotaData := `{t}</table><hr/>{t}{s}</th><th></th><th style='text-align:center'><th></th><td>{e}{s}Voltage{m}</td><td style='text-align:left'>237</td><td>&nbsp;</td><td> V{e}{s}Current{m}</td><td style='text-align:left'>0.053</td><td>&nbsp;</td><td> A{e}{s}Active Power{m}</td><td style='text-align:left'>7</td><td>&nbsp;</td><td> W{e}{s}Apparent Power{m}</td><td style='text-align:left'>13</td><td>&nbsp;</td><td> VA{e}{s}Reactive Power{m}</td><td style='text-align:left'>10</td><td>&nbsp;</td><td> VAr{e}{s}Power Factor{m}</td><td style='text-align:left'>0.59</td><td>&nbsp;</td><td>                         {e}{s}Energy Today{m}</td><td style='text-align:left'>42.42</td><td>&nbsp;</td><td> kWh{e}{s}Energy Yesterday{m}</td><td style='text-align:left'>0.016</td><td>&nbsp;</td><td> kWh{e}{s}Energy Total{m}</td><td style='text-align:left'>3.334</td><td>&nbsp;</td><td> kWh{e}</table><hr/>{t}</table>{t}<tr><td style='width:100%;text-align:center;font-weight:bold;font-size:62px'>ON</td></tr><tr></tr></table>`
+func TestProbeRepeatedTargets(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
 
-	tp := parse(mockTasmotaData)
+	first := newFakePlug(t)
+	second := newFakePlug(t)
 
 	tests := []struct {
-		name     string
-		time     time.Time
-		expected float64
+		name  string
+		query string
+		want  []string
 	}{
 		{
-			name:     "outside midnight window",
-			time:     time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
-			expected: tp.Today,
+			name:  "single target",
+			query: "target=" + first + "&target=" + first,
+			want:  []string{"probe_success 1"},
 		},
 		{
-			name:     "inside midnight window (23:59:30)",
-			time:     time.Date(2024, 1, 15, 23, 59, 30, 0, time.UTC),
-			expected: 0,
-		},
-		{
-			name:     "inside midnight window (00:00:30)",
-			time:     time.Date(2024, 1, 16, 0, 0, 30, 0, time.UTC),
-			expected: 0,
+			name:  "several targets",
+			query: "target=" + first + "&target=" + second + "&target=" + first,
+			want: []string{
+				fmt.Sprintf(`probe_success{target=%q} 1`, first),
+				fmt.Sprintf(`probe_success{target=%q} 1`, second),
+			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			var today float64
-			if isMidnightTransition(tt.time) {
-				today = 0
-			} else {
-				today = tp.Today
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/probe?"+tt.query, nil)
+			tasmotaHandler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
 			}
-			if today != tt.expected {
-				t.Errorf("For time %v, expected Today value %v, got %v", tt.time, tt.expected, today)
+			body := rec.Body.String()
+			for _, want := range tt.want {
+				if strings.Count(body, want) != 1 {
+					t.Errorf("expected output to contain %q once, got:\n%s", want, body)
+				}
 			}
 		})
 	}
 }
+
+func TestProbeAllConcurrency(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	var inFlight, maxInFlight atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, fakePlugPage)
+	})
+
+	// One plug per target, so the targets are tracked separately.
+	var targets []TargetConfig
+	for range 10 {
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+		targets = append(targets, TargetConfig{Address: strings.TrimPrefix(srv.URL, "http://")})
+	}
+
+	results := probeAll(context.Background(), targets, 3)
+	for i, res := range results {
+		if res.err != nil {
+			t.Errorf("probe of %s failed: %s", res.target, res.err)
+		}
+		if res.target != targets[i].Address {
+			t.Errorf("result %d is for %s, want %s", i, res.target, targets[i].Address)
+		}
+	}
+
+	if got := maxInFlight.Load(); got > 3 || got < 2 {
+		t.Errorf("max concurrent probes = %d, want 2 or 3", got)
+	}
+}
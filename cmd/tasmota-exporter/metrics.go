@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+var (
+	probesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_exporter_probes_total",
+		Help: "number of probes made to tasmota plugs, by module and result",
+	}, []string{"module", "result"})
+	probeDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tasmota_exporter_probe_duration_seconds",
+		Help:    "duration of probes made to tasmota plugs, by module",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 3, 5},
+	}, []string{"module"})
+	parseFailuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_exporter_parse_failures_total",
+		Help: "number of responses from tasmota plugs which could not be parsed, by module",
+	}, []string{"module"})
+	buildInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tasmota_exporter_build_info",
+		Help: "build information of the exporter, the value is always 1",
+	}, []string{"version", "revision", "goversion"})
+)
+
+func init() {
+	exporterRegistry.MustRegister(collectors.NewGoCollector())
+	exporterRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	exporterRegistry.MustRegister(probesCounter)
+	exporterRegistry.MustRegister(probeDurationHistogram)
+	exporterRegistry.MustRegister(parseFailuresCounter)
+	exporterRegistry.MustRegister(buildInfoGauge)
+
+	version, revision := "unknown", "unknown"
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		version = bi.Main.Version
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	buildInfoGauge.WithLabelValues(version, revision, runtime.Version()).Set(1)
+}
+
+// parseError is returned when the response of a plug cannot be parsed.
+type parseError struct {
+	err error
+}
+
+func (e parseError) Error() string { return e.err.Error() }
+func (e parseError) Unwrap() error { return e.err }
+
+// observeProbe records a probe made to a plug in the exporter metrics.
+func observeProbe(res probeResult) {
+	result := "success"
+	if res.err != nil {
+		result = "failure"
+	}
+	probesCounter.WithLabelValues(res.module, result).Inc()
+	probeDurationHistogram.WithLabelValues(res.module).Observe(res.duration.Seconds())
+
+	var pe parseError
+	if errors.As(res.err, &pe) {
+		parseFailuresCounter.WithLabelValues(res.module).Inc()
+	}
+}
+
+// registerProbeMetrics registers the metrics of a probe result in reg.
+// Metrics about the plug are only registered if the probe succeeded.
+// The labels are added to every metric, they are used to tell targets
+// apart when several are returned in one exposition.
+func registerProbeMetrics(reg prometheus.Registerer, res probeResult, labels prometheus.Labels) {
+	gauge := func(name, help string, value float64) {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		})
+		g.Set(value)
+		reg.MustRegister(g)
+	}
+
+	success := 0.0
+	if res.err == nil {
+		success = 1
+	}
+	gauge("probe_success", "Displays whether or not the probe was a success", success)
+	gauge("probe_duration_seconds", "Returns how long the probe took to complete in seconds", res.duration.Seconds())
+
+	reg.MustRegister(dailyEnergyCollector{target: res.target, labels: labels})
+
+	if res.tls != nil && len(res.tls.PeerCertificates) > 0 {
+		expiry := res.tls.PeerCertificates[0].NotAfter
+		for _, cert := range res.tls.PeerCertificates[1:] {
+			if cert.NotAfter.Before(expiry) {
+				expiry = cert.NotAfter
+			}
+		}
+		gauge("probe_ssl_earliest_cert_expiry", "Returns last SSL chain expiry in unixtime", float64(expiry.Unix()))
+	}
+
+	if !res.polledAt.IsZero() {
+		gauge("tasmota_last_successful_poll_timestamp_seconds", "unix timestamp of the last successful background poll of the tasmota plug", float64(res.polledAt.Unix()))
+	}
+
+	if res.err != nil {
+		return
+	}
+
+	tp := res.plug
+
+	on := 0.0
+	if tp.On {
+		on = 1
+	}
+	gauge("tasmota_on", "Indicates if the tasmota plug is on/off", on)
+	gauge("tasmota_voltage_volts", "voltage of tasmota plug in volt (V)", tp.Voltage)
+	gauge("tasmota_current_amperes", "current of tasmota plug in ampere (A)", tp.Current)
+	gauge("tasmota_power_watts", "current power of tasmota plug in watts (W), negative when exporting", tp.Power)
+	gauge("tasmota_apparent_power_voltamperes", "apparent power of tasmota plug in volt-amperes (VA)", tp.ApparentPower)
+	gauge("tasmota_reactive_power_voltamperesreactive", "reactive power of tasmota plug in volt-amperes reactive (VAr)", tp.ReactivePower)
+	gauge("tasmota_power_factor", "current power factor of tasmota plug", tp.Factor)
+	gauge("tasmota_today_kwh_total", "todays energy usage total in kilowatts hours (kWh) [manually overriden to 0 between 23:59:00 and 00:00:59]", getTodayValue(tp.Today))
+	gauge("tasmota_yesterday_kwh_total", "yesterdays energy usage total in kilowatts hours (kWh)", tp.Yesterday)
+	gauge("tasmota_kwh_total", "total energy usage in kilowatts hours (kWh)", tp.Total)
+
+	// Tasmota keeps counting consumed energy in Total, exported energy
+	// is tracked separately by the bidirectional drivers.
+	gauge("tasmota_energy_import_kwh_total", "total energy imported (consumed) in kilowatts hours (kWh)", tp.Total)
+	gauge("tasmota_energy_export_kwh_total", "total energy exported (fed back to the grid) in kilowatts hours (kWh)", tp.Export)
+
+	// Plugs without a frequency would report 0 Hz otherwise.
+	if tp.HasFrequency {
+		gauge("tasmota_frequency_hertz", "grid frequency measured by tasmota plug in hertz (Hz)", tp.Frequency)
+	}
+	// The start time is only known with the status module.
+	if !tp.TotalStartTime.IsZero() {
+		gauge("tasmota_energy_total_start_timestamp_seconds", "unix timestamp of when the total energy counter of tasmota plug was last reset", float64(tp.TotalStartTime.Unix()))
+	}
+
+	periodEnergy := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "tasmota_energy_period_kwh",
+		Help:        "energy used in the current calendar period (day, week, month, year) in kilowatts hours (kWh), computed by the exporter",
+		ConstLabels: labels,
+	}, []string{"period"})
+	for period, pe := range res.state.Periods {
+		periodEnergy.WithLabelValues(period).Set(pe.KWh)
+	}
+	reg.MustRegister(periodEnergy)
+
+	if config.Tariff != nil {
+		cost := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "tasmota_energy_cost_total",
+			Help:        "total cost of the energy used since the exporter started tracking the tasmota plug, computed from the configured tariff",
+			ConstLabels: labels,
+		}, []string{"currency"})
+		cost.WithLabelValues(config.Tariff.Currency).Set(res.state.Cost)
+		reg.MustRegister(cost)
+	}
+
+	if res.state.Appliance != nil {
+		registerApplianceMetrics(reg, res.state.Appliance, labels)
+	}
+}
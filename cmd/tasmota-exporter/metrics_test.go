@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExporterMetrics(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	target := newFakePlug(t)
+
+	successes := promtest.ToFloat64(probesCounter.WithLabelValues("web", "success"))
+	failures := promtest.ToFloat64(probesCounter.WithLabelValues("status", "failure"))
+	parseFailures := promtest.ToFloat64(parseFailuresCounter.WithLabelValues("status"))
+
+	if res := runProbe(context.Background(), TargetConfig{Address: target}); res.err != nil {
+		t.Fatalf("web probe failed: %s", res.err)
+	}
+
+	// The fake plug only serves the web UI, which is not valid JSON.
+	if res := runProbe(context.Background(), TargetConfig{Address: target, Module: "status"}); res.err == nil {
+		t.Fatalf("expected status probe of web UI to fail")
+	}
+
+	if got := promtest.ToFloat64(probesCounter.WithLabelValues("web", "success")) - successes; got != 1 {
+		t.Errorf("successful web probes = %v, want 1", got)
+	}
+	if got := promtest.ToFloat64(probesCounter.WithLabelValues("status", "failure")) - failures; got != 1 {
+		t.Errorf("failed status probes = %v, want 1", got)
+	}
+	if got := promtest.ToFloat64(parseFailuresCounter.WithLabelValues("status")) - parseFailures; got != 1 {
+		t.Errorf("status parse failures = %v, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		"go_goroutines",
+		"tasmota_exporter_build_info{",
+		`tasmota_exporter_probe_duration_seconds_count{module="web"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected /metrics to contain %q", want)
+		}
+	}
+}
+
+func TestTotalStartTimeMetric(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	for _, tt := range []struct {
+		name string
+		plug TasmotaPlug
+		want int
+	}{
+		// The web module does not know when the counter was reset.
+		{name: "unknown", plug: TasmotaPlug{Total: 12}, want: 0},
+		{name: "known", plug: TasmotaPlug{Total: 12, TotalStartTime: time.Unix(1678786013, 0)}, want: 1},
+	} {
+		reg := prometheus.NewRegistry()
+		registerProbeMetrics(reg, probeResult{target: "10.0.0.3", plug: tt.plug}, nil)
+
+		got, err := promtest.GatherAndCount(reg, "tasmota_energy_total_start_timestamp_seconds")
+		if err != nil {
+			t.Fatalf("%s: gathering metrics: %s", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: start timestamp series = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFrequencyMetric(t *testing.T) {
+	originalState := state
+	defer func() { state = originalState }()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	for _, tt := range []struct {
+		name string
+		plug TasmotaPlug
+		want int
+	}{
+		// Plugs like the BL0937 ones do not measure the frequency.
+		{name: "unknown", plug: parse(fakePlugPage), want: 0},
+		{name: "known", plug: TasmotaPlug{Frequency: 50.02, HasFrequency: true}, want: 1},
+	} {
+		reg := prometheus.NewRegistry()
+		registerProbeMetrics(reg, probeResult{target: "10.0.0.3", plug: tt.plug}, nil)
+
+		got, err := promtest.GatherAndCount(reg, "tasmota_frequency_hertz")
+		if err != nil {
+			t.Fatalf("%s: gathering metrics: %s", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: frequency series = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
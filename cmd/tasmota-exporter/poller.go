@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PollConfig enables polling the configured targets in the background.
+// Probes of a polled target are then served from the last poll instead
+// of querying the plug on every scrape.
+type PollConfig struct {
+	// Interval is how often targets are polled, unless they set
+	// their own interval. Defaults to defaultPollInterval.
+	Interval time.Duration `yaml:"interval"`
+
+	// StaleAfter is how old the last successful poll of a target can
+	// be before probes of it fail. Defaults to three intervals of the
+	// target.
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+const defaultPollInterval = 30 * time.Second
+
+func (p *PollConfig) validate() error {
+	if p.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+	if p.StaleAfter < 0 {
+		return errors.New("stale_after must be positive")
+	}
+
+	return nil
+}
+
+func (p *PollConfig) interval(target TargetConfig) time.Duration {
+	switch {
+	case target.Interval != 0:
+		return target.Interval
+	case p.Interval != 0:
+		return p.Interval
+	}
+
+	return defaultPollInterval
+}
+
+func (p *PollConfig) staleAfter(target TargetConfig) time.Duration {
+	if p.StaleAfter != 0 {
+		return p.StaleAfter
+	}
+
+	return 3 * p.interval(target)
+}
+
+// cachedProbe is the latest poll of a target.
+type cachedProbe struct {
+	target TargetConfig
+
+	// last is the result of the last poll, successful or not.
+	last probeResult
+
+	// lastSuccess is the result of the last successful poll.
+	lastSuccess probeResult
+}
+
+// poller polls targets in the background and caches the results.
+type poller struct {
+	cfg *PollConfig
+
+	mu    sync.Mutex
+	cache map[string]*cachedProbe
+}
+
+var activePoller *poller
+
+func newPoller(cfg *PollConfig, targets []TargetConfig) *poller {
+	p := &poller{
+		cfg:   cfg,
+		cache: make(map[string]*cachedProbe),
+	}
+
+	for _, target := range targets {
+		p.cache[target.Address] = &cachedProbe{target: target}
+	}
+
+	return p
+}
+
+// run polls every target at its interval until ctx is done.
+func (p *poller) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, cp := range p.cache {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.pollLoop(ctx, cp.target)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *poller) pollLoop(ctx context.Context, target TargetConfig) {
+	ticker := time.NewTicker(p.cfg.interval(target))
+	defer ticker.Stop()
+
+	for {
+		// A poll in flight is not cancelled when ctx is done, so it
+		// can finish when the exporter shuts down.
+		p.poll(context.WithoutCancel(ctx), target)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *poller) poll(ctx context.Context, target TargetConfig) {
+	res := runProbe(ctx, target)
+	res.polledAt = getNow()
+
+	if activeRules != nil {
+		activeRules.evaluate(ctx, target, res)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cp := p.cache[target.Address]
+	cp.last = res
+	if res.err == nil {
+		cp.lastSuccess = res
+	}
+}
+
+// get returns the cached result of target, and false if the target
+// is not polled, or is polled with another module than the one of
+// target. If the last successful poll is older than the staleness
+// limit, the result has an error.
+func (p *poller) get(target TargetConfig) (probeResult, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cp, ok := p.cache[target.Address]
+	if !ok || cp.target.module() != target.module() {
+		return probeResult{}, false
+	}
+
+	res := cp.lastSuccess
+	res.target = target.Address
+	res.duration = cp.last.duration
+
+	switch {
+	case res.polledAt.IsZero():
+		res.err = errors.New("target has not been polled successfully yet")
+	case getNow().Sub(res.polledAt) > p.cfg.staleAfter(cp.target):
+		res.err = fmt.Errorf("last successful poll at %s is stale", res.polledAt.Format(time.RFC3339))
+	}
+
+	return res, true
+}
+
+// probeTarget returns the cached result of target if it is polled in
+// the background with the same module, otherwise it probes the target,
+// sharing the result with concurrent probes of the same target.
+func probeTarget(ctx context.Context, target TargetConfig) probeResult {
+	if activePoller != nil {
+		if res, ok := activePoller.get(target); ok {
+			return res
+		}
+	}
+
+	key := target.Address + "|" + target.module()
+	res, coalesced := coalescer.do(ctx, key, config.ProbeReuseWindow, func(ctx context.Context) probeResult {
+		return runProbe(ctx, target)
+	})
+	if coalesced {
+		coalescedProbesCounter.WithLabelValues(target.Address).Inc()
+		res.target = target.Address
+	}
+
+	return res
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPollerCache(t *testing.T) {
+	originalState := state
+	originalNowFunc := getNow
+	defer func() {
+		state = originalState
+		getNow = originalNowFunc
+	}()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	now := time.Date(2024, 7, 26, 10, 0, 0, 0, time.UTC)
+	getNow = func() time.Time { return now }
+
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			http.Error(w, "rebooting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(fakePlugPage))
+	}))
+	defer srv.Close()
+
+	target := TargetConfig{Address: strings.TrimPrefix(srv.URL, "http://"), Interval: time.Minute}
+	p := newPoller(&PollConfig{}, []TargetConfig{target})
+
+	if res, ok := p.get(target); !ok || res.err == nil {
+		t.Fatalf("expected error before the first poll, got ok=%t err=%v", ok, res.err)
+	}
+
+	if _, ok := p.get(TargetConfig{Address: "not-polled"}); ok {
+		t.Errorf("expected target which is not configured to not be polled")
+	}
+
+	p.poll(context.Background(), target)
+
+	res, _ := p.get(target)
+	if res.err != nil {
+		t.Fatalf("unexpected error after successful poll: %s", res.err)
+	}
+	if res.plug.Power != 7 || !res.polledAt.Equal(now) {
+		t.Errorf("unexpected cached result: %+v", res)
+	}
+
+	// A failed poll keeps serving the last successful one until it is
+	// stale, which is three intervals by default.
+	healthy = false
+	now = now.Add(time.Minute)
+	p.poll(context.Background(), target)
+
+	res, _ = p.get(target)
+	if res.err != nil || res.plug.Power != 7 {
+		t.Errorf("expected last successful poll after a failed poll, got %+v", res)
+	}
+
+	now = now.Add(3 * time.Minute)
+	res, _ = p.get(target)
+	if res.err == nil {
+		t.Errorf("expected stale result to fail")
+	}
+
+	reg := prometheus.NewRegistry()
+	registerProbeMetrics(reg, res, nil)
+
+	expected := `
+# HELP probe_success Displays whether or not the probe was a success
+# TYPE probe_success gauge
+probe_success 0
+# HELP tasmota_last_successful_poll_timestamp_seconds unix timestamp of the last successful background poll of the tasmota plug
+# TYPE tasmota_last_successful_poll_timestamp_seconds gauge
+tasmota_last_successful_poll_timestamp_seconds 1.721988e+09
+`
+	err := promtest.GatherAndCompare(reg, strings.NewReader(expected), "probe_success", "tasmota_last_successful_poll_timestamp_seconds")
+	if err != nil {
+		t.Errorf("unexpected metrics for stale result: %s", err)
+	}
+}
+
+func TestProbeTargetOtherModule(t *testing.T) {
+	originalState := state
+	originalPoller := activePoller
+	defer func() {
+		state = originalState
+		activePoller = originalPoller
+	}()
+	state = &stateStore{Targets: make(map[string]*targetState)}
+
+	target := TargetConfig{Address: newFakePlug(t)}
+	activePoller = newPoller(&PollConfig{}, []TargetConfig{target})
+	activePoller.poll(context.Background(), target)
+
+	if res := probeTarget(context.Background(), target); res.err != nil || res.polledAt.IsZero() {
+		t.Errorf("expected the polled result for the module of the target, got %+v", res)
+	}
+
+	// The fake plug only serves the web UI, a live probe with the
+	// status module fails instead of returning the poll of the web
+	// module.
+	res := probeTarget(context.Background(), TargetConfig{Address: target.Address, Module: "status"})
+	if res.err == nil || !res.polledAt.IsZero() {
+		t.Errorf("expected a live probe with the status module, got %+v", res)
+	}
+}
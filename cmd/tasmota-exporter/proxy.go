@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc connects to addr, like net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// parseProxyURL parses the proxy_url of a module, an empty string means
+// no proxy. The scheme is http for proxies supporting HTTP CONNECT, or
+// socks5 (or socks5h, which is the same, hosts are always resolved by
+// the proxy), optionally with a username and password.
+func parseProxyURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		// The error contains the URL, which can contain a password.
+		return nil, errors.New("invalid proxy URL")
+	}
+
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, must be http, socks5 or socks5h", u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", u.Redacted())
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return nil, fmt.Errorf("proxy URL %q must not have a path or query", u.Redacted())
+	}
+
+	return u, nil
+}
+
+// proxyDial returns a function connecting to addresses through the
+// proxy at proxyURL, using dial to connect to the proxy itself.
+func proxyDial(proxyURL *url.URL, dial dialFunc) dialFunc {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "1080"
+		if proxyURL.Scheme == "http" {
+			port = "80"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	if proxyURL.Scheme == "http" {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, proxyAddr)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := httpConnect(ctx, conn, proxyURL.User, addr); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("connecting through proxy %s: %w", proxyAddr, err)
+			}
+
+			return conn, nil
+		}
+	}
+
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	// SOCKS5 only fails with a forward dialer which is not a
+	// proxy.ContextDialer, dialFunc always is.
+	d, _ := proxy.SOCKS5("tcp", proxyAddr, auth, contextDialer(dial))
+
+	return d.(proxy.ContextDialer).DialContext
+}
+
+// contextDialer makes a dialFunc a proxy.ContextDialer.
+type contextDialer dialFunc
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// httpConnect asks the HTTP proxy on the other end of conn to open a
+// tunnel to addr.
+func httpConnect(ctx context.Context, conn net.Conn, user *url.Userinfo, addr string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	// The proxy does not send anything after its response until the
+	// client does, so nothing is lost in the buffer of the reader.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy answered %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RuleConfig switches a plug when one of its values stays above a
+// threshold for a while, e.g. to switch off a heater left on. Rules are
+// evaluated after every background poll.
+type RuleConfig struct {
+	// Name identifies the rule in metrics and logs.
+	Name string `yaml:"name"`
+
+	// Targets are the addresses the rule applies to. Defaults to all
+	// the targets in the configuration file.
+	Targets []string `yaml:"targets"`
+
+	// Metric is the value compared to the threshold: power, current,
+	// voltage or apparent_power.
+	Metric string `yaml:"metric"`
+
+	// Threshold is the value the metric must exceed.
+	Threshold float64 `yaml:"threshold"`
+
+	// For is how long the metric must exceed the threshold before the
+	// action is taken. Zero acts on the first poll above it.
+	For time.Duration `yaml:"for"`
+
+	// Action is what to do with the relay: off, on or toggle.
+	Action powerAction `yaml:"action"`
+
+	// Relay is the index of the relay switched. Defaults to 1.
+	Relay int `yaml:"relay"`
+
+	// Cooldown is how long after the action the rule is not acted on
+	// again for the same target.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// ruleMetrics are the values rules can compare to their threshold.
+var ruleMetrics = map[string]func(TasmotaPlug) float64{
+	"power":          func(tp TasmotaPlug) float64 { return tp.Power },
+	"current":        func(tp TasmotaPlug) float64 { return tp.Current },
+	"voltage":        func(tp TasmotaPlug) float64 { return tp.Voltage },
+	"apparent_power": func(tp TasmotaPlug) float64 { return tp.ApparentPower },
+}
+
+func (r *RuleConfig) validate(c *Config) error {
+	if r.Name == "" {
+		return errors.New("name must be set")
+	}
+	if _, ok := ruleMetrics[r.Metric]; !ok {
+		return fmt.Errorf("unknown metric %q, must be power, current, voltage or apparent_power", r.Metric)
+	}
+	if r.Action != powerOn && r.Action != powerOff && r.Action != powerToggle {
+		return fmt.Errorf("unknown action %q, must be on, off or toggle", r.Action)
+	}
+	if r.Relay < 0 || r.Relay > maxPowerRelay {
+		return fmt.Errorf("relay must be between 1 and %d", maxPowerRelay)
+	}
+	if r.For < 0 || r.Cooldown < 0 {
+		return errors.New("for and cooldown must be positive")
+	}
+
+	for _, target := range r.Targets {
+		if !slices.ContainsFunc(c.Targets, func(t TargetConfig) bool { return t.Address == target }) {
+			return fmt.Errorf("target %s is not in the targets of the configuration file", target)
+		}
+	}
+
+	return nil
+}
+
+func (r *RuleConfig) appliesTo(target string) bool {
+	return len(r.Targets) == 0 || slices.Contains(r.Targets, target)
+}
+
+func (r *RuleConfig) relay() int {
+	return max(r.Relay, 1)
+}
+
+// ruleState is the state of a rule for a target, exposed as
+// tasmota_exporter_rule_state.
+type ruleState int
+
+const (
+	// ruleInactive is when the metric is below the threshold.
+	ruleInactive ruleState = iota
+
+	// rulePending is when the metric exceeds the threshold, but not
+	// for long enough yet.
+	rulePending
+
+	// ruleCoolingDown is after the action was taken, until the
+	// cooldown has passed.
+	ruleCoolingDown
+)
+
+var (
+	ruleStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tasmota_exporter_rule_state",
+		Help: "state of a cutoff rule for a tasmota plug, 0 is inactive, 1 is pending and 2 is cooling down after acting",
+	}, []string{"rule", "target"})
+	ruleActionsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tasmota_exporter_rule_actions_total",
+		Help: "number of times a cutoff rule switched a tasmota plug, by result",
+	}, []string{"rule", "target", "result"})
+)
+
+func init() {
+	exporterRegistry.MustRegister(ruleStateGauge)
+	exporterRegistry.MustRegister(ruleActionsCounter)
+}
+
+// ruleTracker tracks a rule for a single target.
+type ruleTracker struct {
+	state ruleState
+
+	// since is when the metric started exceeding the threshold.
+	since time.Time
+
+	// actedAt is when the action was last taken.
+	actedAt time.Time
+}
+
+// ruleEngine evaluates the rules against the results of polls.
+type ruleEngine struct {
+	rules []RuleConfig
+
+	mu       sync.Mutex
+	trackers map[string]*ruleTracker
+}
+
+var activeRules *ruleEngine
+
+func newRuleEngine(rules []RuleConfig) *ruleEngine {
+	return &ruleEngine{
+		rules:    rules,
+		trackers: make(map[string]*ruleTracker),
+	}
+}
+
+// evaluate updates the rules applying to target with the result of a
+// poll, and takes their action when they have exceeded their threshold
+// for long enough. Failed polls leave the rules as they are.
+func (e *ruleEngine) evaluate(ctx context.Context, target TargetConfig, res probeResult) {
+	if res.err != nil {
+		return
+	}
+
+	for _, rule := range e.rules {
+		if !rule.appliesTo(target.Address) {
+			continue
+		}
+
+		if e.shouldAct(rule, target.Address, res.plug) {
+			e.act(ctx, rule, target)
+		}
+	}
+}
+
+// shouldAct updates the tracker of rule for target and reports if the
+// action is due.
+func (e *ruleEngine) shouldAct(rule RuleConfig, target string, plug TasmotaPlug) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := rule.Name + "|" + target
+	t, ok := e.trackers[key]
+	if !ok {
+		t = &ruleTracker{}
+		e.trackers[key] = t
+	}
+	defer func() {
+		ruleStateGauge.WithLabelValues(rule.Name, target).Set(float64(t.state))
+	}()
+
+	now := getNow()
+	coolingDown := !t.actedAt.IsZero() && now.Sub(t.actedAt) < rule.Cooldown
+
+	if ruleMetrics[rule.Metric](plug) <= rule.Threshold {
+		t.since = time.Time{}
+		t.state = ruleInactive
+		if coolingDown {
+			t.state = ruleCoolingDown
+		}
+
+		return false
+	}
+
+	if t.since.IsZero() {
+		t.since = now
+	}
+
+	switch {
+	case coolingDown:
+		t.state = ruleCoolingDown
+		return false
+	case now.Sub(t.since) < rule.For:
+		t.state = rulePending
+		return false
+	}
+
+	// The action is taken at most once per poll, the tracker is reset
+	// if it succeeds.
+	t.state = rulePending
+	return true
+}
+
+func (e *ruleEngine) act(ctx context.Context, rule RuleConfig, target TargetConfig) {
+	audit := slog.With("component", "audit", "client", "rule:"+rule.Name, "target", target.Address, "action", rule.Action, "relay", rule.relay())
+
+	module, err := config.module(target.Module)
+	var on bool
+	if err == nil {
+		on, err = switchPower(ctx, target.Address, module, rule.relay(), rule.Action)
+	}
+	if err != nil {
+		ruleActionsCounter.WithLabelValues(rule.Name, target.Address, "failure").Inc()
+		audit.Error("rule failed to switch plug", "error", err)
+		return
+	}
+
+	ruleActionsCounter.WithLabelValues(rule.Name, target.Address, "success").Inc()
+	audit.Warn("rule switched plug", "on", on, "metric", rule.Metric, "threshold", rule.Threshold)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t := e.trackers[rule.Name+"|"+target.Address]
+	t.actedAt = getNow()
+	t.since = time.Time{}
+	t.state = ruleCoolingDown
+	ruleStateGauge.WithLabelValues(rule.Name, target.Address).Set(float64(t.state))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRuleEngine(t *testing.T) {
+	originalNowFunc := getNow
+	defer func() { getNow = originalNowFunc }()
+	now := time.Date(2024, 1, 15, 20, 0, 0, 0, time.UTC)
+	getNow = func() time.Time { return now }
+
+	var mu sync.Mutex
+	var commands []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, r.URL.Query().Get("cmnd"))
+		fmt.Fprint(w, `{"POWER":"OFF"}`)
+	}))
+	defer srv.Close()
+	target := TargetConfig{Address: strings.TrimPrefix(srv.URL, "http://")}
+
+	engine := newRuleEngine([]RuleConfig{{
+		Name:      "heater",
+		Metric:    "power",
+		Threshold: 2000,
+		For:       5 * time.Minute,
+		Action:    powerOff,
+		Cooldown:  30 * time.Minute,
+	}})
+
+	steps := []struct {
+		after        time.Duration
+		power        float64
+		failed       bool
+		wantState    ruleState
+		wantCommands int
+	}{
+		{power: 100, wantState: ruleInactive},
+		{after: time.Minute, power: 2500, wantState: rulePending},
+		{after: 4 * time.Minute, power: 2500, wantState: rulePending},
+		// Dropping below the threshold restarts the wait.
+		{after: time.Minute, power: 1500, wantState: ruleInactive},
+		{after: time.Minute, power: 2500, wantState: rulePending},
+		// Failed polls do not change anything.
+		{after: 3 * time.Minute, failed: true, wantState: rulePending},
+		{after: 2 * time.Minute, power: 2500, wantState: ruleCoolingDown, wantCommands: 1},
+		// The plug is off, the cooldown prevents acting again when it
+		// is switched back on.
+		{after: time.Minute, power: 0, wantState: ruleCoolingDown, wantCommands: 1},
+		{after: 20 * time.Minute, power: 2500, wantState: ruleCoolingDown, wantCommands: 1},
+		// The time spent above the threshold during the cooldown
+		// counts, the rule acts as soon as the cooldown is over.
+		{after: 10 * time.Minute, power: 2500, wantState: ruleCoolingDown, wantCommands: 2},
+	}
+
+	successes := promtest.ToFloat64(ruleActionsCounter.WithLabelValues("heater", target.Address, "success"))
+
+	for i, step := range steps {
+		now = now.Add(step.after)
+
+		res := probeResult{target: target.Address, plug: TasmotaPlug{Power: step.power}}
+		if step.failed {
+			res.err = fmt.Errorf("timeout")
+		}
+		engine.evaluate(context.Background(), target, res)
+
+		if got := ruleState(promtest.ToFloat64(ruleStateGauge.WithLabelValues("heater", target.Address))); got != step.wantState {
+			t.Errorf("step %d: state = %d, want %d", i, got, step.wantState)
+		}
+
+		mu.Lock()
+		got := len(commands)
+		mu.Unlock()
+		if got != step.wantCommands {
+			t.Errorf("step %d: commands = %d, want %d", i, got, step.wantCommands)
+		}
+	}
+
+	if commands[0] != "Power1 off" {
+		t.Errorf("command = %q, want %q", commands[0], "Power1 off")
+	}
+	if got := promtest.ToFloat64(ruleActionsCounter.WithLabelValues("heater", target.Address, "success")) - successes; got != 2 {
+		t.Errorf("successful actions = %v, want 2", got)
+	}
+}
+
+func TestRuleTargets(t *testing.T) {
+	engine := newRuleEngine([]RuleConfig{{
+		Name:      "heater",
+		Targets:   []string{"heater.local"},
+		Metric:    "power",
+		Threshold: 2000,
+		Action:    powerOff,
+	}})
+
+	engine.evaluate(context.Background(), TargetConfig{Address: "kettle.local"}, probeResult{plug: TasmotaPlug{Power: 3000}})
+	if len(engine.trackers) != 0 {
+		t.Error("expected the rule not to apply to other targets")
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			Poll:    &PollConfig{},
+			Targets: []TargetConfig{{Address: "heater.local"}},
+			Rules:   []RuleConfig{{Name: "heater", Metric: "power", Threshold: 2000, Action: powerOff}},
+		}
+	}
+
+	if err := base().validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for name, modify := range map[string]func(c *Config){
+		"without polling":    func(c *Config) { c.Poll = nil },
+		"unknown metric":     func(c *Config) { c.Rules[0].Metric = "temperature" },
+		"unknown action":     func(c *Config) { c.Rules[0].Action = "explode" },
+		"unknown target":     func(c *Config) { c.Rules[0].Targets = []string{"kettle.local"} },
+		"duplicate names":    func(c *Config) { c.Rules = append(c.Rules, c.Rules[0]) },
+		"missing name":       func(c *Config) { c.Rules[0].Name = "" },
+		"negative for":       func(c *Config) { c.Rules[0].For = -time.Minute },
+		"relay out of range": func(c *Config) { c.Rules[0].Relay = 33 },
+	} {
+		c := base()
+		modify(c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
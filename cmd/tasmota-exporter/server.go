@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// serverReadTimeout is how long a client may take to send its
+	// request.
+	serverReadTimeout = 10 * time.Second
+
+	// serverWriteTimeout is how long a request may take to be answered,
+	// it must leave room for /metrics/all to probe every target.
+	serverWriteTimeout = 2 * time.Minute
+
+	// serverIdleTimeout is how long idle keep-alive connections are
+	// kept open.
+	serverIdleTimeout = 2 * time.Minute
+
+	// shutdownTimeout is how long requests in flight are waited for
+	// when the exporter is asked to stop.
+	shutdownTimeout = 30 * time.Second
+)
+
+// ready reports if the exporter is serving probes, it is false before
+// the server has started and once it is shutting down.
+var ready atomic.Bool
+
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", landingHandler)
+	mux.HandleFunc("/probe", tasmotaHandler)
+	mux.HandleFunc("/energy", energyHandler)
+	mux.HandleFunc("/daily", dailyHandler)
+	mux.HandleFunc("/influx", influxHandler)
+	mux.HandleFunc("/metrics/all", allTargetsHandler)
+	mux.Handle("/metrics", metricsHandler)
+
+	return mux
+}
+
+// newHandler returns the handler of the server. The control API
+// authenticates clients with its own tokens, and the health endpoints
+// are open so liveness and readiness probes work without credentials.
+// Everything else requires the basic auth users of webConfig.
+func newHandler(webConfig *WebConfig) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", webConfig.withBasicAuth(newMux()))
+	mux.HandleFunc("/-/healthy", healthyHandler)
+	mux.HandleFunc("/-/ready", readyHandler)
+	mux.HandleFunc("/api/v1/targets/{target}/power", powerHandler)
+
+	return mux
+}
+
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// healthyHandler reports that the exporter is running.
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Healthy")
+}
+
+// readyHandler reports if the exporter is serving probes.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "Not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "Ready")
+}
+
+// serve serves requests on the listeners until ctx is done, then stops
+// accepting new requests and waits for the ones in flight, like probes,
+// to finish.
+func serve(ctx context.Context, srv *http.Server, listeners ...net.Listener) error {
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func() {
+			errCh <- srv.Serve(ln)
+		}()
+	}
+
+	ready.Store(true)
+	defer ready.Store(false)
+
+	var serveErr error
+	select {
+	case serveErr = <-errCh:
+		// One listener failing stops the others too.
+		serveErr = fmt.Errorf("serving: %w", serveErr)
+	case <-ctx.Done():
+	}
+
+	ready.Store(false)
+	slog.Info("shutting down, waiting for requests in flight")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return errors.Join(serveErr, fmt.Errorf("shutting down server: %w", err))
+	}
+
+	remaining := len(listeners)
+	if serveErr != nil {
+		remaining--
+	}
+	for range remaining {
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			serveErr = errors.Join(serveErr, err)
+		}
+	}
+
+	return serveErr
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthAndReady(t *testing.T) {
+	mux := newHandler(&WebConfig{})
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	ready.Store(false)
+	if code := get("/-/healthy"); code != http.StatusOK {
+		t.Errorf("expected healthy to return 200, got %d", code)
+	}
+	if code := get("/-/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected ready to return 503 before serving, got %d", code)
+	}
+
+	ready.Store(true)
+	defer ready.Store(false)
+	if code := get("/-/ready"); code != http.StatusOK {
+		t.Errorf("expected ready to return 200 while serving, got %d", code)
+	}
+}
+
+func TestServeDrainsRequestsOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	mux := newMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, newServer(mux), ln)
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	respCh := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			respCh <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		respCh <- response{body: string(body), err: err}
+	}()
+
+	<-started
+	if !ready.Load() {
+		t.Error("expected to be ready while serving")
+	}
+	cancel()
+
+	// The server must wait for the request in flight.
+	select {
+	case err := <-serveErr:
+		t.Fatalf("serve returned before the request in flight finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if ready.Load() {
+		t.Error("expected not to be ready while shutting down")
+	}
+
+	close(release)
+
+	resp := <-respCh
+	if resp.err != nil || resp.body != "done" {
+		t.Errorf("expected the request in flight to finish, got %q, %v", resp.body, resp.err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("unexpected error from serve: %s", err)
+	}
+}
+
+func TestServeMultipleListeners(t *testing.T) {
+	var addrs []string
+	var listeners []net.Listener
+	for range 2 {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listening: %s", err)
+		}
+		listeners = append(listeners, ln)
+		addrs = append(addrs, ln.Addr().String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, newServer(newHandler(&WebConfig{})), listeners...)
+	}()
+
+	for _, addr := range addrs {
+		resp, err := http.Get("http://" + addr + "/-/healthy")
+		if err != nil {
+			t.Fatalf("requesting %s: %s", addr, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected 200 from %s, got %s", addr, resp.Status)
+		}
+	}
+
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Errorf("unexpected error from serve: %s", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateFlushInterval is how often the state is written to disk. The
+// exporter often runs on a Raspberry Pi, so we avoid writing to the SD
+// card on every probe.
+const stateFlushInterval = time.Minute
+
+// targetState is what the exporter remembers about a target across
+// probes and restarts.
+type targetState struct {
+	// LastTotal is the Total counter seen on the previous probe, used
+	// to compute the energy used between two probes.
+	LastTotal float64 `json:"last_total"`
+
+	// Cost is the accumulated cost of the energy used by the target.
+	Cost float64 `json:"cost"`
+
+	// Periods is the energy used per calendar period, keyed by
+	// period name.
+	Periods map[string]periodEnergy `json:"periods"`
+
+	// LastToday and LastYesterday are the Today and Yesterday counters
+	// seen on the previous probe, used to detect when the plug starts
+	// a new day.
+	LastToday     float64 `json:"last_today"`
+	LastYesterday float64 `json:"last_yesterday"`
+
+	// Daily is the closing total of each day, oldest first.
+	Daily []dailyTotal `json:"daily"`
+
+	// Appliance is the state of the appliance plugged into the target,
+	// if detected.
+	Appliance *applianceState `json:"appliance,omitempty"`
+}
+
+// stateStore holds the state of all targets and persists it to disk.
+type stateStore struct {
+	path string
+
+	mu      sync.Mutex
+	dirty   bool
+	Targets map[string]*targetState `json:"targets"`
+}
+
+// loadState reads the state from path. A missing file or an empty path
+// returns an empty state.
+func loadState(path string) (*stateStore, error) {
+	s := &stateStore{
+		path:    path,
+		Targets: make(map[string]*targetState),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parsing state file: %w", err)
+	}
+
+	if s.Targets == nil {
+		s.Targets = make(map[string]*targetState)
+	}
+
+	return s, nil
+}
+
+// update calls fn with the state of target, creating it if needed, and
+// marks the store as dirty.
+func (s *stateStore) update(target string, fn func(ts *targetState, known bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, known := s.Targets[target]
+	if !known {
+		ts = &targetState{}
+		s.Targets[target] = ts
+	}
+
+	fn(ts, known)
+	s.dirty = true
+}
+
+// save writes the state to disk if it has changed since the last save.
+func (s *stateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	if err := s.write(data); err != nil {
+		// Make sure we try again on the next save.
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *stateStore) write(data []byte) error {
+	// Write to a temporary file and rename it so a crash while
+	// writing does not leave a truncated state file behind.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+
+	return nil
+}
+
+// run saves the state every interval until ctx is done.
+func (s *stateStore) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				slog.Error("failed to save state", "error", err)
+			}
+		}
+	}
+}
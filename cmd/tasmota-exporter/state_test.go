@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState() on missing file error = %s", err)
+	}
+
+	s.update("plug", func(ts *targetState, known bool) {
+		if known {
+			t.Errorf("expected new target to be unknown")
+		}
+		ts.LastTotal = 12.5
+		ts.Cost = 3.75
+	})
+
+	if err := s.save(); err != nil {
+		t.Fatalf("save() error = %s", err)
+	}
+
+	loaded, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState() error = %s", err)
+	}
+
+	if diff := cmp.Diff(s.Targets, loaded.Targets, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("unexpected loaded state (-want +got):\n%s", diff)
+	}
+}
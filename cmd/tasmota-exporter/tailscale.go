@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"tailscale.com/envknob"
+	"tailscale.com/tsnet"
+)
+
+var (
+	overrideTailscaleHostname = envknob.String("TASMOTA_EXPORTER_TAILSCALE_HOSTNAME")
+	overrideTailscaleAuthKey  = envknob.String("TASMOTA_EXPORTER_TAILSCALE_AUTHKEY")
+	overrideTailscaleStateDir = envknob.String("TASMOTA_EXPORTER_TAILSCALE_STATE_DIR")
+)
+
+// TailscaleConfig serves the exporter on a tailnet with an embedded
+// Tailscale node, in addition to the regular listener. Every field can
+// be overridden with a TASMOTA_EXPORTER_TAILSCALE_* environment
+// variable.
+type TailscaleConfig struct {
+	// Hostname is the name of the node on the tailnet, the node is
+	// only started if it is set.
+	Hostname string `yaml:"hostname"`
+
+	// AuthKey is used to add the node to the tailnet the first time it
+	// starts. If empty, TS_AUTHKEY is used, or a login URL is logged.
+	AuthKey string `yaml:"auth_key"`
+
+	// StateDir is where the node keeps its state, it must be
+	// persisted so the node keeps its identity across restarts.
+	StateDir string `yaml:"state_dir"`
+
+	// ListenAddr is the address listened on in the tailnet. Defaults
+	// to defaultTailscaleListenAddr.
+	ListenAddr string `yaml:"listen_addr"`
+}
+
+const defaultTailscaleListenAddr = ":80"
+
+// tailscale returns the Tailscale configuration with the environment
+// overrides applied, or nil if the node is not enabled.
+func (c *Config) tailscale() *TailscaleConfig {
+	ts := TailscaleConfig{}
+	if c.Tailscale != nil {
+		ts = *c.Tailscale
+	}
+
+	if overrideTailscaleHostname != "" {
+		ts.Hostname = overrideTailscaleHostname
+	}
+	if overrideTailscaleAuthKey != "" {
+		ts.AuthKey = overrideTailscaleAuthKey
+	}
+	if overrideTailscaleStateDir != "" {
+		ts.StateDir = overrideTailscaleStateDir
+	}
+	if ts.ListenAddr == "" {
+		ts.ListenAddr = defaultTailscaleListenAddr
+	}
+
+	if ts.Hostname == "" {
+		return nil
+	}
+
+	return &ts
+}
+
+func (t *TailscaleConfig) validate() error {
+	if t.ListenAddr == "" {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(t.ListenAddr); err != nil {
+		return fmt.Errorf("listen_addr: %w", err)
+	}
+
+	return nil
+}
+
+// listenTailscale starts the Tailscale node and listens on the tailnet.
+// The returned server must be closed when the listener is no longer
+// used.
+func listenTailscale(cfg *TailscaleConfig) (*tsnet.Server, net.Listener, error) {
+	if cfg.StateDir == "" {
+		return nil, nil, errors.New("tailscale state_dir must be set, the node needs to keep its identity across restarts")
+	}
+
+	srv := &tsnet.Server{
+		Hostname: cfg.Hostname,
+		AuthKey:  cfg.AuthKey,
+		Dir:      cfg.StateDir,
+		UserLogf: func(format string, args ...any) {
+			slog.Info(fmt.Sprintf(format, args...), "component", "tailscale")
+		},
+		Logf: func(format string, args ...any) {
+			slog.Debug(fmt.Sprintf(format, args...), "component", "tailscale")
+		},
+	}
+
+	ln, err := srv.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		srv.Close()
+		return nil, nil, fmt.Errorf("listening on tailnet: %w", err)
+	}
+
+	return srv, ln, nil
+}
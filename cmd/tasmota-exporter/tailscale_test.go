@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestTailscaleConfig(t *testing.T) {
+	defer func(hostname, authKey, stateDir string) {
+		overrideTailscaleHostname = hostname
+		overrideTailscaleAuthKey = authKey
+		overrideTailscaleStateDir = stateDir
+	}(overrideTailscaleHostname, overrideTailscaleAuthKey, overrideTailscaleStateDir)
+	overrideTailscaleHostname, overrideTailscaleAuthKey, overrideTailscaleStateDir = "", "", ""
+
+	if ts := (&Config{}).tailscale(); ts != nil {
+		t.Errorf("expected tailscale to be disabled without a hostname, got %+v", ts)
+	}
+
+	cfg := &Config{Tailscale: &TailscaleConfig{Hostname: "tasmota", StateDir: "/var/lib/ts"}}
+	ts := cfg.tailscale()
+	if ts == nil || ts.Hostname != "tasmota" || ts.StateDir != "/var/lib/ts" || ts.ListenAddr != defaultTailscaleListenAddr {
+		t.Errorf("unexpected config %+v", ts)
+	}
+
+	overrideTailscaleHostname = "tasmota-grandma"
+	overrideTailscaleAuthKey = "tskey-auth-123"
+	ts = cfg.tailscale()
+	if ts == nil || ts.Hostname != "tasmota-grandma" || ts.AuthKey != "tskey-auth-123" || ts.StateDir != "/var/lib/ts" {
+		t.Errorf("expected the environment to override the config file, got %+v", ts)
+	}
+	if cfg.Tailscale.Hostname != "tasmota" {
+		t.Error("expected the config file to be left untouched")
+	}
+
+	if ts := (&Config{}).tailscale(); ts == nil || ts.Hostname != "tasmota-grandma" {
+		t.Errorf("expected the environment alone to enable tailscale, got %+v", ts)
+	}
+
+	if err := (&Config{Tailscale: &TailscaleConfig{ListenAddr: "80"}}).validate(); err == nil {
+		t.Error("expected an error for an invalid listen address")
+	}
+}
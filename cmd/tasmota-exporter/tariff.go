@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TariffConfig describes the price of energy. Rates are checked in
+// order and the first one matching the local time is used, if none
+// match, Price is used.
+//
+//	tariff:
+//	  currency: GBP
+//	  price: 0.25
+//	  rates:
+//	    - name: night
+//	      start: "00:30"
+//	      end: "07:30"
+//	      price: 0.09
+//	    - name: weekend
+//	      days: [weekend]
+//	      price: 0.20
+type TariffConfig struct {
+	// Currency is exposed as a label on the cost metric.
+	Currency string `yaml:"currency"`
+
+	// Price is the default price per kWh.
+	Price float64 `yaml:"price"`
+
+	Rates []TariffRate `yaml:"rates"`
+}
+
+// TariffRate is a price that applies during a time of the day on
+// some days of the week.
+type TariffRate struct {
+	Name string `yaml:"name"`
+
+	// Start and End are the local time of day on the format 15:04,
+	// End is exclusive. If End is before Start, the rate wraps
+	// around midnight. If both are empty, the rate applies all day.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	// Days the rate applies on, short weekday names (mon, tue, ...)
+	// or weekday/weekend. If empty, the rate applies every day.
+	Days []string `yaml:"days"`
+
+	// Price is the price per kWh.
+	Price float64 `yaml:"price"`
+
+	start, end int
+	days       map[time.Weekday]bool
+}
+
+var weekdayNames = map[string][]time.Weekday{
+	"mon":     {time.Monday},
+	"tue":     {time.Tuesday},
+	"wed":     {time.Wednesday},
+	"thu":     {time.Thursday},
+	"fri":     {time.Friday},
+	"sat":     {time.Saturday},
+	"sun":     {time.Sunday},
+	"weekday": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend": {time.Saturday, time.Sunday},
+}
+
+func (t *TariffConfig) validate() error {
+	if t.Currency == "" {
+		return errors.New("currency must be set")
+	}
+
+	for i := range t.Rates {
+		if err := t.Rates[i].validate(); err != nil {
+			return fmt.Errorf("rate %d (%s): %w", i, t.Rates[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *TariffRate) validate() error {
+	if (r.Start == "") != (r.End == "") {
+		return errors.New("start and end must be set together")
+	}
+
+	if r.Start != "" {
+		var err error
+		r.start, err = parseMinuteOfDay(r.Start)
+		if err != nil {
+			return fmt.Errorf("start: %w", err)
+		}
+		r.end, err = parseMinuteOfDay(r.End)
+		if err != nil {
+			return fmt.Errorf("end: %w", err)
+		}
+	}
+
+	r.days = make(map[time.Weekday]bool)
+	for _, day := range r.Days {
+		weekdays, ok := weekdayNames[strings.ToLower(day)]
+		if !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+		for _, weekday := range weekdays {
+			r.days[weekday] = true
+		}
+	}
+
+	return nil
+}
+
+func parseMinuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, must be on the format 15:04", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// matches reports if the rate applies at t, t must already be in the
+// configured timezone.
+func (r *TariffRate) matches(t time.Time) bool {
+	if len(r.days) > 0 && !r.days[t.Weekday()] {
+		return false
+	}
+
+	if r.start == r.end {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if r.start < r.end {
+		return minute >= r.start && minute < r.end
+	}
+
+	// The rate wraps around midnight, e.g. 22:00 to 06:00.
+	return minute >= r.start || minute < r.end
+}
+
+// priceAt returns the price per kWh at t.
+func (t *TariffConfig) priceAt(now time.Time) float64 {
+	for _, rate := range t.Rates {
+		if rate.matches(now) {
+			return rate.Price
+		}
+	}
+
+	return t.Price
+}
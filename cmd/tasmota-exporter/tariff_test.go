@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTariffPriceAt(t *testing.T) {
+	tariff := &TariffConfig{
+		Currency: "GBP",
+		Price:    0.25,
+		Rates: []TariffRate{
+			{Name: "night", Start: "22:30", End: "06:30", Price: 0.09},
+			{Name: "weekend", Days: []string{"weekend"}, Price: 0.20},
+			{Name: "friday-evening", Start: "17:00", End: "20:00", Days: []string{"fri"}, Price: 0.40},
+		},
+	}
+	if err := tariff.validate(); err != nil {
+		t.Fatalf("validate() error = %s", err)
+	}
+
+	tests := []struct {
+		name string
+		time time.Time
+		want float64
+	}{
+		{
+			name: "weekday day - default price",
+			time: time.Date(2024, 7, 24, 12, 0, 0, 0, time.UTC), // Wednesday
+			want: 0.25,
+		},
+		{
+			name: "weekday night before midnight",
+			time: time.Date(2024, 7, 24, 23, 0, 0, 0, time.UTC),
+			want: 0.09,
+		},
+		{
+			name: "weekday night after midnight",
+			time: time.Date(2024, 7, 25, 6, 29, 0, 0, time.UTC),
+			want: 0.09,
+		},
+		{
+			name: "night end is exclusive",
+			time: time.Date(2024, 7, 25, 6, 30, 0, 0, time.UTC),
+			want: 0.25,
+		},
+		{
+			name: "weekend day",
+			time: time.Date(2024, 7, 27, 12, 0, 0, 0, time.UTC), // Saturday
+			want: 0.20,
+		},
+		{
+			name: "weekend night, first match wins",
+			time: time.Date(2024, 7, 27, 23, 0, 0, 0, time.UTC),
+			want: 0.09,
+		},
+		{
+			name: "friday evening",
+			time: time.Date(2024, 7, 26, 18, 0, 0, 0, time.UTC),
+			want: 0.40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tariff.priceAt(tt.time); got != tt.want {
+				t.Errorf("priceAt(%s) = %v, want %v", tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTariffValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		tariff TariffConfig
+	}{
+		{
+			name:   "missing currency",
+			tariff: TariffConfig{Price: 1},
+		},
+		{
+			name:   "start without end",
+			tariff: TariffConfig{Currency: "EUR", Rates: []TariffRate{{Start: "10:00"}}},
+		},
+		{
+			name:   "invalid time",
+			tariff: TariffConfig{Currency: "EUR", Rates: []TariffRate{{Start: "25:00", End: "10:00"}}},
+		},
+		{
+			name:   "unknown day",
+			tariff: TariffConfig{Currency: "EUR", Rates: []TariffRate{{Days: []string{"someday"}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.tariff.validate(); err == nil {
+				t.Errorf("validate() expected error")
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+timezone: Europe/Oslo
+state_file: /tmp/state.json
+tariff:
+  currency: NOK
+  price: 1.5
+  rates:
+    - name: night
+      start: "22:00"
+      end: "06:00"
+      price: 0.9
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %s", err)
+	}
+
+	if cfg.Location().String() != "Europe/Oslo" {
+		t.Errorf("Location() = %s, want Europe/Oslo", cfg.Location())
+	}
+
+	if cfg.Tariff == nil || cfg.Tariff.Currency != "NOK" || len(cfg.Tariff.Rates) != 1 {
+		t.Errorf("unexpected tariff: %+v", cfg.Tariff)
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("loadConfig() expected error for missing file")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// WebConfig secures the HTTP server of the exporter. It uses the same
+// format as the web configuration file of the Prometheus exporters.
+type WebConfig struct {
+	// TLSServerConfig enables TLS if set.
+	TLSServerConfig *TLSServerConfig `yaml:"tls_server_config"`
+
+	// BasicAuthUsers maps usernames to bcrypt hashes of their
+	// passwords. If set, every request needs one of the users, except
+	// /-/healthy, /-/ready and the control API, which has its own
+	// tokens.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig is the TLS configuration of the HTTP server.
+type TLSServerConfig struct {
+	// CertFile and KeyFile are the certificate and key of the server.
+	// Relative paths are relative to the web configuration file.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// ClientAuth is the policy for client certificates, one of
+	// NoClientCert (the default), RequestClientCert,
+	// RequireAnyClientCert, VerifyClientCertIfGiven or
+	// RequireAndVerifyClientCert.
+	ClientAuth string `yaml:"client_auth_type"`
+
+	// ClientCAFile holds the CAs client certificates are verified
+	// against.
+	ClientCAFile string `yaml:"client_ca_file"`
+
+	// MinVersion is the minimum TLS version accepted, TLS10 to TLS13.
+	// Defaults to TLS12.
+	MinVersion string `yaml:"min_version"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":      tls.VersionTLS12,
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// loadWebConfig reads the web configuration file at path, an empty path
+// means no TLS and no authentication.
+func loadWebConfig(path string) (*WebConfig, error) {
+	cfg := &WebConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading web config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing web config file: %w", err)
+	}
+
+	if err := cfg.validate(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("validating web config file: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func (c *WebConfig) validate(dir string) error {
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("basic_auth_users: password of %s is not a bcrypt hash: %w", user, err)
+		}
+	}
+
+	if c.TLSServerConfig != nil {
+		if err := c.TLSServerConfig.validate(dir); err != nil {
+			return fmt.Errorf("tls_server_config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (t *TLSServerConfig) validate(dir string) error {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return errors.New("cert_file and key_file are required")
+	}
+
+	clientAuth, ok := clientAuthTypes[t.ClientAuth]
+	if !ok {
+		return fmt.Errorf("unknown client_auth_type %q", t.ClientAuth)
+	}
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		return fmt.Errorf("unknown min_version %q", t.MinVersion)
+	}
+
+	verifies := clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert
+	if verifies && t.ClientCAFile == "" {
+		return fmt.Errorf("client_ca_file is required with client_auth_type %s", t.ClientAuth)
+	}
+	if !verifies && t.ClientCAFile != "" {
+		return errors.New("client_ca_file is only used with client_auth_type VerifyClientCertIfGiven or RequireAndVerifyClientCert")
+	}
+
+	for _, p := range []*string{&t.CertFile, &t.KeyFile, &t.ClientCAFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of the server, or nil if TLS
+// is not enabled.
+func (c *WebConfig) tlsConfig() (*tls.Config, error) {
+	t := c.TLSServerConfig
+	if t == nil {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[t.ClientAuth],
+		MinVersion:   tlsVersions[t.MinVersion],
+	}
+
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file: %w", err)
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", t.ClientCAFile)
+		}
+	}
+
+	return cfg, nil
+}
+
+// dummyBcryptHash is compared against for unknown users, so they take as
+// long to reject as wrong passwords and usernames cannot be guessed from
+// the response time.
+const dummyBcryptHash = "$2a$10$xFfeUpOq.a2usu/xaxCcfuR8ZTQXuzeM3BrcgNIC.4uEoF9FNgV3q"
+
+// withBasicAuth requires requests to next to authenticate as one of the
+// basic auth users, if there are any.
+func (c *WebConfig) withBasicAuth(next http.Handler) http.Handler {
+	if len(c.BasicAuthUsers) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok {
+			hash, known := c.BasicAuthUsers[user]
+			if !known {
+				hash = dummyBcryptHash
+			}
+
+			err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+			if err == nil && known {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="tasmota-exporter"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testCert is a generated certificate and its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert generates a certificate for 127.0.0.1, signed by parent
+// or self-signed if parent is nil.
+func newTestCert(t *testing.T, name string, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("creating certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %s", err)
+	}
+
+	return &testCert{cert: cert, key: key}
+}
+
+// write writes the certificate and key as PEM files to dir.
+func (c *testCert) write(t *testing.T, dir, name string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("marshalling key: %s", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// serveWebConfig serves the exporter with the web config at path and
+// returns its URL.
+func serveWebConfig(t *testing.T, path string) string {
+	t.Helper()
+
+	webConfig, err := loadWebConfig(path)
+	if err != nil {
+		t.Fatalf("loading web config: %s", err)
+	}
+	tlsConfig, err := webConfig.tlsConfig()
+	if err != nil {
+		t.Fatalf("loading TLS config: %s", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		scheme = "https"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(ctx, newServer(newHandler(webConfig)), ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return scheme + "://" + ln.Addr().String()
+}
+
+func TestWebConfigTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, "ca", true, nil)
+	ca.write(t, dir, "ca")
+	newTestCert(t, "server", false, ca).write(t, dir, "server")
+	client := newTestCert(t, "client", false, ca)
+	otherClient := newTestCert(t, "other", false, nil)
+
+	// Relative paths are relative to the web config file.
+	path := filepath.Join(dir, "web.yml")
+	err := os.WriteFile(path, []byte(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := serveWebConfig(t, path) + "/-/healthy"
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name    string
+		certs   []tls.Certificate
+		wantErr bool
+	}{
+		{name: "no client certificate", wantErr: true},
+		{name: "untrusted client certificate", certs: []tls.Certificate{otherClient.tlsCertificate()}, wantErr: true},
+		{name: "trusted client certificate", certs: []tls.Certificate{client.tlsCertificate()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: tt.certs},
+			}}
+
+			resp, err := c.Get(url)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("expected the request to fail, got %s", resp.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected 200, got %s", resp.Status)
+			}
+		})
+	}
+
+	// The server answers plaintext requests with a 400.
+	resp, err := http.Get("http" + url[len("https"):])
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected plaintext requests to fail, got %s", resp.Status)
+		}
+	}
+}
+
+func TestWebConfigBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webConfig := &WebConfig{BasicAuthUsers: map[string]string{"prometheus": string(hash)}}
+	if err := webConfig.validate(""); err != nil {
+		t.Fatalf("validating web config: %s", err)
+	}
+	handler := webConfig.withBasicAuth(newMux())
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		noAuth   bool
+		want     int
+	}{
+		{name: "no credentials", noAuth: true, want: http.StatusUnauthorized},
+		{name: "unknown user", user: "grafana", password: "secret", want: http.StatusUnauthorized},
+		{name: "wrong password", user: "prometheus", password: "wrong", want: http.StatusUnauthorized},
+		{name: "valid credentials", user: "prometheus", password: "secret", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestHealthWithoutBasicAuth(t *testing.T) {
+	webConfig := &WebConfig{BasicAuthUsers: map[string]string{"prometheus": dummyBcryptHash}}
+	handler := newHandler(webConfig)
+
+	ready.Store(true)
+	defer ready.Store(false)
+
+	for path, want := range map[string]int{
+		"/-/healthy": http.StatusOK,
+		"/-/ready":   http.StatusOK,
+		"/metrics":   http.StatusUnauthorized,
+		"/probe":     http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s without credentials: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestWebConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  WebConfig
+	}{
+		{
+			name: "plaintext password",
+			cfg:  WebConfig{BasicAuthUsers: map[string]string{"prometheus": "secret"}},
+		},
+		{
+			name: "missing key",
+			cfg:  WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "server.crt"}},
+		},
+		{
+			name: "unknown client auth type",
+			cfg:  WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "Always"}},
+		},
+		{
+			name: "verifying without CA",
+			cfg:  WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "RequireAndVerifyClientCert"}},
+		},
+		{
+			name: "unknown TLS version",
+			cfg:  WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: "server.crt", KeyFile: "server.key", MinVersion: "SSL3"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.validate(""); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
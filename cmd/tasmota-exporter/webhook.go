@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WebhookConfig posts events to a URL.
+type WebhookConfig struct {
+	// Name identifies the webhook in metrics and logs.
+	Name string `yaml:"name"`
+
+	// URL is where the events are posted, over HTTP or HTTPS.
+	URL string `yaml:"url"`
+
+	// Events are the types of events posted. Defaults to all of them.
+	Events []eventType `yaml:"events"`
+
+	// Targets are the addresses the events are posted for. Defaults to
+	// all targets.
+	Targets []string `yaml:"targets"`
+
+	// Headers are added to the requests, e.g. for authentication.
+	Headers map[string]string `yaml:"headers"`
+
+	// Template is the Go template of the body, executed with the
+	// event. Defaults to the event as JSON.
+	Template string `yaml:"template"`
+
+	// Timeout is how long a single request may take. Defaults to
+	// defaultWebhookTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Retry controls retries of failed requests. Unlike requests to
+	// plugs, they default to defaultWebhookAttempts attempts with a
+	// defaultWebhookBackoff backoff.
+	Retry RetryConfig `yaml:"retry"`
+
+	template *template.Template
+}
+
+const (
+	defaultWebhookTimeout  = 10 * time.Second
+	defaultWebhookAttempts = 3
+	defaultWebhookBackoff  = time.Second
+)
+
+// webhookFuncs are the functions available in the templates of
+// webhooks, json renders a value as JSON, e.g. to quote strings.
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (w *WebhookConfig) validate() error {
+	if w.Name == "" {
+		return errors.New("name must be set")
+	}
+
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+
+	for _, typ := range w.Events {
+		if !slices.Contains(eventTypes, typ) {
+			return fmt.Errorf("unknown event %q, must be offline, online, relay_changed, counter_reset or appliance_finished", typ)
+		}
+	}
+
+	if w.Timeout < 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	if err := w.Retry.validate(); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+
+	if w.Template != "" {
+		w.template, err = template.New(w.Name).Funcs(webhookFuncs).Option("missingkey=error").Parse(w.Template)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (w *WebhookConfig) wants(ev event) bool {
+	return (len(w.Events) == 0 || slices.Contains(w.Events, ev.Type)) &&
+		(len(w.Targets) == 0 || slices.Contains(w.Targets, ev.Target))
+}
+
+// body renders the body of the request posting ev.
+func (w *WebhookConfig) body(ev event) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(ev)
+	}
+
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, ev); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+var webhookDeliveriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tasmota_exporter_webhook_deliveries_total",
+	Help: "number of events posted to a webhook, by result",
+}, []string{"webhook", "result"})
+
+func init() {
+	exporterRegistry.MustRegister(webhookDeliveriesCounter)
+}
+
+// webhookSink posts the events it receives to a webhook, one at a time.
+type webhookSink struct {
+	cfg    WebhookConfig
+	client *http.Client
+	logger *slog.Logger
+}
+
+func newWebhookSink(cfg WebhookConfig) *webhookSink {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	return &webhookSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: timeout},
+		logger: slog.With("component", "webhook", "webhook", cfg.Name),
+	}
+}
+
+// run posts the events of ch until it is closed. Deliveries in progress
+// when ctx is done fail without further retries.
+func (s *webhookSink) run(ctx context.Context, ch <-chan event) {
+	for ev := range ch {
+		if !s.cfg.wants(ev) {
+			continue
+		}
+
+		logger := s.logger.With("event", ev.Type, "target", ev.Target)
+		if err := s.deliver(ctx, ev); err != nil {
+			webhookDeliveriesCounter.WithLabelValues(s.cfg.Name, "failure").Inc()
+			logger.Error("failed to post event", "error", err)
+			continue
+		}
+
+		webhookDeliveriesCounter.WithLabelValues(s.cfg.Name, "success").Inc()
+		logger.Debug("posted event")
+	}
+}
+
+// permanentError wraps errors which are not worth retrying.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func (s *webhookSink) deliver(ctx context.Context, ev event) error {
+	body, err := s.cfg.body(ev)
+	if err != nil {
+		return err
+	}
+
+	attempts := s.cfg.Retry.Attempts
+	if attempts == 0 {
+		attempts = defaultWebhookAttempts
+	}
+	backoff := s.cfg.Retry.Backoff
+	if backoff == 0 {
+		backoff = defaultWebhookBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.post(ctx, body)
+		if err == nil || attempt >= attempts || errors.As(err, new(permanentError)) {
+			return err
+		}
+
+		s.logger.Info("posting event failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (s *webhookSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tasmota-exporter")
+	for name, value := range s.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("webhook returned %s", resp.Status)
+	// Client errors other than timeouts and rate limits will not be
+	// fixed by sending the same request again.
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout {
+		return permanentError{err}
+	}
+
+	return err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// webhookReceiver records the requests it gets, answering with the given
+// statuses in turn and 204 once they are exhausted.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) (*webhookReceiver, string) {
+	t.Helper()
+
+	rcv := &webhookReceiver{statuses: statuses}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.bodies = append(rcv.bodies, string(body))
+		rcv.headers = append(rcv.headers, r.Header.Clone())
+
+		status := http.StatusNoContent
+		if len(rcv.statuses) > 0 {
+			status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return rcv, srv.URL
+}
+
+func (r *webhookReceiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.bodies...)
+}
+
+func TestWebhookSink(t *testing.T) {
+	rcv, url := newWebhookReceiver(t, http.StatusBadRequest, http.StatusServiceUnavailable)
+
+	cfg := WebhookConfig{
+		Name:     "chat",
+		URL:      url,
+		Events:   []eventType{eventOffline, eventApplianceFinished},
+		Targets:  []string{"washer.local"},
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+		Template: `{"text": {{ printf "%s is %s" .Target .Type | json }}}`,
+		Retry:    RetryConfig{Attempts: 2, Backoff: time.Millisecond},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validating webhook: %s", err)
+	}
+
+	successes := promtest.ToFloat64(webhookDeliveriesCounter.WithLabelValues("chat", "success"))
+	failures := promtest.ToFloat64(webhookDeliveriesCounter.WithLabelValues("chat", "failure"))
+
+	ch := make(chan event, 4)
+	// Not retried after the 400.
+	ch <- event{Type: eventOffline, Target: "washer.local"}
+	// Retried after the 503, it succeeds on the second attempt.
+	ch <- event{Type: eventOffline, Target: "washer.local"}
+	// Filtered out.
+	ch <- event{Type: eventOnline, Target: "washer.local"}
+	ch <- event{Type: eventOffline, Target: "kettle.local"}
+	close(ch)
+
+	newWebhookSink(cfg).run(context.Background(), ch)
+
+	want := []string{
+		`{"text": "washer.local is offline"}`,
+		`{"text": "washer.local is offline"}`,
+		`{"text": "washer.local is offline"}`,
+	}
+	if diff := cmp.Diff(want, rcv.received()); diff != "" {
+		t.Errorf("unexpected requests (-want +got):\n%s", diff)
+	}
+	if got := rcv.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the configured header", got)
+	}
+
+	if got := promtest.ToFloat64(webhookDeliveriesCounter.WithLabelValues("chat", "success")) - successes; got != 1 {
+		t.Errorf("successful deliveries = %v, want 1", got)
+	}
+	if got := promtest.ToFloat64(webhookDeliveriesCounter.WithLabelValues("chat", "failure")) - failures; got != 1 {
+		t.Errorf("failed deliveries = %v, want 1", got)
+	}
+}
+
+func TestWebhookDefaultBody(t *testing.T) {
+	rcv, url := newWebhookReceiver(t)
+
+	cfg := WebhookConfig{Name: "default", URL: url}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validating webhook: %s", err)
+	}
+
+	want := event{
+		Type:   eventCounterReset,
+		Target: "washer.local",
+		Time:   time.Date(2024, 1, 15, 20, 0, 0, 0, time.UTC),
+		Plug:   &TasmotaPlug{On: true, Total: 0.1},
+	}
+
+	bus := newEventBus(&EventsConfig{})
+	ch := bus.subscribe(cfg.Name)
+	bus.publish(want)
+	bus.close()
+
+	newWebhookSink(cfg).run(context.Background(), ch)
+
+	bodies := rcv.received()
+	if len(bodies) != 1 {
+		t.Fatalf("requests = %d, want 1", len(bodies))
+	}
+	var got event
+	if err := json.Unmarshal([]byte(bodies[0]), &got); err != nil {
+		t.Fatalf("decoding body: %s", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected event (-want +got):\n%s", diff)
+	}
+	if got := rcv.headers[0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	for name, cfg := range map[string]WebhookConfig{
+		"missing name":     {URL: "https://example.com/hook"},
+		"missing url":      {Name: "chat"},
+		"unknown scheme":   {Name: "chat", URL: "ftp://example.com/hook"},
+		"unknown event":    {Name: "chat", URL: "https://example.com/hook", Events: []eventType{"exploded"}},
+		"invalid template": {Name: "chat", URL: "https://example.com/hook", Template: "{{ .Target "},
+		"negative timeout": {Name: "chat", URL: "https://example.com/hook", Timeout: -time.Second},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	events := EventsConfig{Webhooks: []WebhookConfig{
+		{Name: "chat", URL: "https://example.com/hook"},
+		{Name: "chat", URL: "https://example.com/other"},
+	}}
+	if err := events.validate(); err == nil {
+		t.Error("duplicate names: expected an error")
+	}
+}
@@ -29,7 +29,7 @@
 
             subPackage = ["cmd/tasmota-exporter"];
 
-            vendorHash = "sha256-zN1AlvLzE2X/OUH6RzoCP+5tY46s9uWPeFfgTt3jNUw=";
+            vendorHash = "sha256-O8MCg97q5lwqA2TMC8FaOfEd4rowEKe0F8a7tbgFlT0=";
           }) {};
       };
     }